@@ -0,0 +1,68 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"reflect"
+	"strconv"
+)
+
+// RegisterTypeAlias makes q print the short name instead of t's name, wherever
+// it shows a type, e.g.
+//
+//	q.RegisterTypeAlias(reflect.TypeOf(pb.VeryLongMessageName{}), "Msg")
+//
+// prints a *pb.VeryLongMessageName as &Msg{...}, and a slice of them as
+// []*Msg{...}. It's meant for verbose generated types, like protobuf and gRPC
+// messages. Types without an alias are printed as usual. Registering a type
+// again replaces its alias, and "" removes it.
+func RegisterTypeAlias(t reflect.Type, short string) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+
+	if short == "" {
+		delete(std.opts.typeAliases, t)
+		return
+	}
+	if std.opts.typeAliases == nil {
+		std.opts.typeAliases = make(map[reflect.Type]string)
+	}
+	std.opts.typeAliases[t] = short
+}
+
+// typeName returns the name of t as q prints it: the same as t.String(), but
+// with the aliases set with RegisterTypeAlias(), including inside pointer,
+// slice, array, map, and channel types.
+func (p *valuePrinter) typeName(t reflect.Type) string {
+	if len(p.opts.typeAliases) == 0 {
+		return t.String()
+	}
+	if alias, ok := p.opts.typeAliases[t]; ok {
+		return alias
+	}
+	if t.Name() != "" {
+		return t.String()
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return "*" + p.typeName(t.Elem())
+	case reflect.Slice:
+		return "[]" + p.typeName(t.Elem())
+	case reflect.Array:
+		return "[" + strconv.Itoa(t.Len()) + "]" + p.typeName(t.Elem())
+	case reflect.Map:
+		return "map[" + p.typeName(t.Key()) + "]" + p.typeName(t.Elem())
+	case reflect.Chan:
+		switch t.ChanDir() {
+		case reflect.RecvDir:
+			return "<-chan " + p.typeName(t.Elem())
+		case reflect.SendDir:
+			return "chan<- " + p.typeName(t.Elem())
+		}
+		return "chan " + p.typeName(t.Elem())
+	}
+	return t.String()
+}
@@ -0,0 +1,39 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"reflect"
+	"testing"
+)
+
+type veryLongMessageName struct{ ID int }
+
+// TestFormatTypeAlias verifies that formatValue() prints type aliases in place
+// of the types' names, including inside other types.
+func TestFormatTypeAlias(t *testing.T) {
+	opts := formatOptions{typeAliases: map[reflect.Type]string{
+		reflect.TypeOf(veryLongMessageName{}): "Msg",
+	}}
+
+	testCases := []struct {
+		id   int
+		arg  interface{}
+		want string
+	}{
+		{1, veryLongMessageName{1}, "Msg{ID:1}"},
+		{2, &veryLongMessageName{1}, "&Msg{ID:1}"},
+		{3, []*veryLongMessageName{nil}, "[]*Msg{\n    (*Msg)(nil),\n}"},
+		{4, map[string]veryLongMessageName(nil), "map[string]Msg{}"},
+		{5, (*veryLongMessageName)(nil), "(*Msg)(nil)"},
+		{6, point{1, 2}, "q.point{x:1, y:2}"},
+	}
+
+	for _, tc := range testCases {
+		if got := formatValue(tc.arg, opts); got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:  %s\nwant: %s", tc.id, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// atomicWrites returns true if flushes replace the log file instead of
+// appending to it. See SetAtomicWrite(). A file set with SetFile() is written
+// to as usual, and so is the compressed log, whose gzip stream is kept open.
+func (l *logger) atomicWrites() bool {
+	return l.atomicWrite && l.file == nil && !l.compressed
+}
+
+// flushAtomic is flush() for SetAtomicWrite(). It writes the log file with the
+// logger's buffer added to a temporary file, and renames that over the log
+// file, so readers see the old version or the new one, never a partial write.
+// The log file is $TMPDIR/q, or $TMPDIR/q-<user>, as with openFile().
+func (l *logger) flushAtomic() error {
+	b := l.buf.Bytes()
+	if l.plain() {
+		b = []byte(stripColor(l.buf.String()))
+	}
+
+	path := filepath.Join(os.TempDir(), "q")
+	if l.perUser {
+		path += "-" + userName()
+	}
+	err := l.replaceFile(path, b)
+	if os.IsPermission(err) && !l.perUser {
+		path += "-" + userName()
+		err = l.replaceFile(path, b)
+	}
+
+	l.buf.Reset()
+	l.unflushed = 0
+	if err != nil {
+		return fmt.Errorf("failed to flush q buffer to %q: %v", path, err)
+	}
+	l.stats.bytes += int64(len(b))
+	return nil
+}
+
+// replaceFile replaces the file at path with its current content followed by
+// b, by writing them to a temporary file in the same directory and renaming
+// it. If the file is new or empty, whatever belongs at the top of it is
+// written first; see startFile().
+func (l *logger) replaceFile(path string, b []byte) error {
+	old, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once it's been renamed
+
+	if _, err := tmp.Write(old); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := l.startFile(tmp, tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := l.writeRetry(tmp, b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
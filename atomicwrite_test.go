@@ -0,0 +1,61 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestAtomicWrite verifies that with atomic writes, each flush replaces the log
+// file with a new one holding everything written so far, writes the file
+// header once, and leaves no temporary files behind.
+func TestAtomicWrite(t *testing.T) {
+	dir, cleanup := setTempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "q")
+
+	l := newLogger()
+	l.atomicWrite = true
+	l.fileHeader = "== myserver =="
+
+	write := func(s string) os.FileInfo {
+		l.buf.WriteString(s)
+		if err := l.flush(); err != nil {
+			t.Fatalf("flush() failed: %v", err)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("failed to stat %q: %v", path, err)
+		}
+		return fi
+	}
+
+	first := write("one\n")
+	assertFileContents(t, path, "== myserver ==\none\n")
+	second := write("two\n")
+	assertFileContents(t, path, "== myserver ==\none\ntwo\n")
+
+	if os.SameFile(first, second) {
+		t.Fatalf("the log file was appended to, want it replaced")
+	}
+	if second.Mode().Perm() != 0600 {
+		t.Fatalf("\ngot:  %v\nwant: %v", second.Mode().Perm(), os.FileMode(0600))
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read %q: %v", dir, err)
+	}
+	if len(files) != 1 {
+		var names []string
+		for _, fi := range files {
+			names = append(names, fi.Name())
+		}
+		t.Fatalf("\ngot:  %q\nwant: [q]", names)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// backoffPPS is the number of calls per second a site can make before q
+	// backs off from it. See SetAutoBackoff().
+	backoffPPS = 100
+
+	// maxBackoff is the largest divisor q backs off to, i.e. it always logs at
+	// least 1 in maxBackoff calls.
+	maxBackoff = 1 << 16
+)
+
+// backoff is the state that SetAutoBackoff() keeps for each call site.
+type backoff struct {
+	divisor     int       // log 1 in this many calls. 0 means 1.
+	calls       int       // total calls, to pick 1 in divisor
+	windowStart time.Time // start of the current 1s window
+	windowCalls int       // calls since windowStart
+}
+
+// backOff counts a call from the site of c, and returns true if it should be
+// skipped because the site is being backed off. When the site starts or stops
+// being backed off, or the rate changes, it writes a note saying so.
+func (l *logger) backOff(c call) bool {
+	b := &l.site(c.file, c.line).backoff
+	if b.divisor == 0 {
+		b.divisor = 1
+	}
+	old := b.divisor
+
+	now := l.now()
+	if elapsed := now.Sub(b.windowStart); elapsed >= time.Second {
+		// Recover while the site is quiet: halve the divisor as long as the
+		// rate of the last window would fit in a quarter of it.
+		rate := float64(b.windowCalls) / elapsed.Seconds()
+		for b.divisor > 1 && rate <= float64(backoffPPS*b.divisor/4) {
+			b.divisor /= 2
+		}
+		b.windowStart, b.windowCalls = now, 0
+	}
+
+	b.windowCalls++
+	for b.windowCalls > backoffPPS*b.divisor && b.divisor < maxBackoff {
+		b.divisor *= 2
+	}
+
+	if b.divisor != old {
+		var msg string
+		if b.divisor == 1 {
+			msg = fmt.Sprintf("q: %s:%d quieted down, logging every call", l.displayFile(c.file), c.line)
+		} else {
+			msg = fmt.Sprintf("q: %s:%d is called more than %d times a second, logging 1 in %d calls",
+				l.displayFile(c.file), c.line, backoffPPS, b.divisor)
+		}
+		l.printHeader(c)
+		l.output(colorize(msg, bold))
+	}
+
+	b.calls++
+	return b.calls%b.divisor != 0
+}
@@ -0,0 +1,68 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAutoBackoff verifies that a site that logs too often is backed off in
+// steps, and recovers once it quiets down.
+func TestAutoBackoff(t *testing.T) {
+	start := time.Date(2024, 3, 10, 17, 4, 5, 0, time.UTC)
+	now := start
+	l := newLogger()
+	l.autoBackoff = true
+	l.clock = func() time.Time { return now }
+
+	c := call{
+		funcName: "main.main",
+		file:     "testdata/sample1.go",
+		line:     14,
+		names:    []string{"i"},
+	}
+	log := func(i int, at time.Duration) {
+		now = start.Add(at)
+		c.values = []interface{}{i}
+		l.print(c)
+	}
+
+	// 300 calls in 300ms, then 20 calls over 2s.
+	for i := 0; i < 300; i++ {
+		log(i, time.Duration(i)*time.Millisecond)
+	}
+	for i := 0; i < 20; i++ {
+		log(300+i, time.Second+time.Duration(i)*100*time.Millisecond)
+	}
+
+	var notes []string
+	logged := 0
+	for _, line := range strings.Split(stripColor(l.buf.String()), "\n") {
+		switch {
+		case strings.Contains(line, " q: "):
+			notes = append(notes, strings.SplitN(line, " q: ", 2)[1])
+		case strings.Contains(line, " i=int("):
+			logged++
+		}
+	}
+
+	wantNotes := []string{
+		"testdata/sample1.go:14 is called more than 100 times a second, logging 1 in 2 calls",
+		"testdata/sample1.go:14 is called more than 100 times a second, logging 1 in 4 calls",
+		"testdata/sample1.go:14 quieted down, logging every call",
+	}
+	if !reflect.DeepEqual(notes, wantNotes) {
+		t.Fatalf("\ngot notes:  %q\nwant notes: %q", notes, wantNotes)
+	}
+
+	// 100 calls, then 1 in 2 of the next 100, then 1 in 4 of the next 100 and
+	// the first 10 slow ones, then every call.
+	if want := 100 + 50 + 27 + 10; logged != want {
+		t.Fatalf("logged %d calls, want %d", logged, want)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// captureSite is the name used in the header line above captured output.
+const captureSite = "CaptureStd"
+
+// capture redirects one of the standard streams into the q log through a pipe.
+type capture struct {
+	name   string        // "stdout" or "stderr"
+	target **os.File     // &os.Stdout or &os.Stderr
+	orig   *os.File      // the file *target pointed to before the capture
+	w      *os.File      // write end of the pipe, installed in *target
+	done   chan struct{} // closed once everything written to w has been logged
+}
+
+var (
+	captureMu sync.Mutex // protects captures
+	captures  []*capture
+)
+
+// CaptureStd redirects os.Stdout and os.Stderr into the q log file, so the
+// program's own output is interleaved with the output of Q(). Each captured
+// line is timestamped like any other log line and marked with [stdout] or
+// [stderr]. If tee is true, the output is also written to the original stdout
+// and stderr. Call RestoreStd() to undo it.
+//
+// Only writes that go through the os.Stdout and os.Stderr variables are
+// captured. Anything holding on to the original *os.File, like a log.Logger
+// created before CaptureStd() was called, still writes to the terminal.
+func CaptureStd(tee bool) error {
+	captureMu.Lock()
+	defer captureMu.Unlock()
+
+	if len(captures) != 0 {
+		return errors.New("q: stdout and stderr are already captured")
+	}
+
+	stdout, err := startCapture("stdout", &os.Stdout, tee)
+	if err != nil {
+		return err
+	}
+
+	stderr, err := startCapture("stderr", &os.Stderr, tee)
+	if err != nil {
+		stdout.stop()
+		return err
+	}
+
+	captures = []*capture{stdout, stderr}
+	return nil
+}
+
+// RestoreStd undoes CaptureStd(). It returns after everything written to the
+// captured streams has been logged.
+func RestoreStd() {
+	captureMu.Lock()
+	defer captureMu.Unlock()
+
+	for _, c := range captures {
+		c.stop()
+	}
+	captures = nil
+}
+
+// startCapture replaces *target with the write end of a pipe, and starts a
+// goroutine that logs everything read from the other end.
+func startCapture(name string, target **os.File, tee bool) (*capture, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to capture %s: %v", name, err)
+	}
+
+	c := &capture{
+		name:   name,
+		target: target,
+		orig:   *target,
+		w:      w,
+		done:   make(chan struct{}),
+	}
+	*target = w
+
+	var src io.Reader = r
+	if tee {
+		src = io.TeeReader(r, c.orig)
+	}
+
+	go func() {
+		defer close(c.done)
+		defer r.Close()
+
+		br := bufio.NewReader(src)
+		for {
+			line, err := br.ReadString('\n')
+			if line != "" {
+				std.logCaptured(c.name, strings.TrimSuffix(line, "\n"))
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return c, nil
+}
+
+// stop puts the original file back and waits for the pipe to be drained.
+func (c *capture) stop() {
+	*c.target = c.orig
+	c.w.Close()
+	<-c.done
+}
+
+// logCaptured writes one line of captured output to the log file.
+func (l *logger) logCaptured(name, line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Flush the buffered writes to disk.
+	defer l.flush()
+
+	if header := l.header(captureSite, "", 0); header != "" {
+		l.writeHeader(header)
+	}
+
+	marker := colorize("["+name+"]", bold)
+	l.output(marker, line)
+}
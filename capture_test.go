@@ -0,0 +1,70 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCaptureStd verifies that output written to os.Stdout and os.Stderr
+// between CaptureStd() and RestoreStd() ends up in the log file with a marker.
+func TestCaptureStd(t *testing.T) {
+	dir, cleanup := setTempDir(t)
+	defer cleanup()
+
+	stdout, stderr := os.Stdout, os.Stderr
+	if err := CaptureStd(false); err != nil {
+		t.Fatalf("CaptureStd: %v", err)
+	}
+	if err := CaptureStd(false); err == nil {
+		t.Fatalf("CaptureStd: got err == nil when already capturing, want err != nil")
+	}
+
+	fmt.Println("hello from stdout")
+	fmt.Fprintln(os.Stderr, "hello from stderr")
+	RestoreStd()
+
+	if os.Stdout != stdout || os.Stderr != stderr {
+		t.Fatalf("RestoreStd: os.Stdout and os.Stderr were not restored")
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "q"))
+	if err != nil {
+		t.Fatalf("failed to read log file: %v", err)
+	}
+
+	got := string(b)
+	for _, want := range []string{
+		colorize("[stdout]", bold) + " hello from stdout\n",
+		colorize("[stderr]", bold) + " hello from stderr\n",
+		captureSite + "]",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("\nlog file:\n%s\nmissing: %q", got, want)
+		}
+	}
+}
+
+// setTempDir points $TMPDIR at a new temporary directory, so the test doesn't
+// write to the real log file. The returned func removes the directory and
+// restores $TMPDIR.
+func setTempDir(t *testing.T) (dir string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "q")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+
+	orig := os.Getenv("TMPDIR")
+	os.Setenv("TMPDIR", dir)
+	return dir, func() {
+		os.Setenv("TMPDIR", orig)
+		os.RemoveAll(dir)
+	}
+}
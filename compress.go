@@ -0,0 +1,61 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"compress/gzip"
+	"fmt"
+	"os"
+)
+
+// openGzip makes sure the compressed log file is open, and that l.gz writes to
+// it. See SetCompressed(). Unlike the plain log file, it's kept open between
+// flushes, since a gzip stream can't be reopened and added to. If the file
+// has been removed or truncated since it was opened, e.g. to clear the log,
+// a new file or stream is started.
+func (l *logger) openGzip() error {
+	if l.gz != nil {
+		fi, err := os.Stat(l.gzFile.Name())
+		cur, statErr := l.gzFile.Stat()
+		switch {
+		case err != nil || statErr != nil || !os.SameFile(fi, cur):
+			l.closeGzip()
+		case fi.Size() == 0:
+			// Truncated. Whatever is left of the stream is gone, so start
+			// over with a new one.
+			l.gz.Reset(l.gzFile)
+			return nil
+		default:
+			return nil
+		}
+	}
+
+	f, err := l.openFile()
+	if err != nil {
+		return err
+	}
+	l.gzFile = f
+	l.gz = gzip.NewWriter(f)
+	return nil
+}
+
+// closeGzip ends the gzip stream and closes the compressed log file, if it's
+// open. Until the stream is ended, the file can be decompressed, but gzip
+// complains that it ends too soon.
+func (l *logger) closeGzip() error {
+	if l.gz == nil {
+		return nil
+	}
+
+	err := l.gz.Close()
+	if cerr := l.gzFile.Close(); err == nil {
+		err = cerr
+	}
+	l.gz, l.gzFile = nil, nil
+	if err != nil {
+		return fmt.Errorf("failed to close q log file: %v", err)
+	}
+	return nil
+}
@@ -5,9 +5,12 @@ q.Q() is a fast way to pretty-print variables. It's easier than typing
 fmt.Printf("%#v", whatever). The output will be colorized and nicely formatted.
 The output goes to $TMPDIR/q, away from the noise of stdout.
 
-q exports a single Q() function. This is how you use it:
+This is how you use it:
     import "github.com/y0ssar1an/q"
     ...
     q.Q(a, b, c)
+
+Qn() does the same, but first prints a note saying why you're logging:
+    q.Qn("after retry", a, b, c)
 */
 package q
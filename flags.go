@@ -0,0 +1,127 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// flag is one of the named bits, or groups of bits, of a flag type. See
+// RegisterFlags().
+type flag struct {
+	bits uint64
+	name string
+}
+
+// RegisterFlags makes q print values of the same type as zero as the names of
+// the flags that are set in them, e.g.
+//
+//	q.RegisterFlags(0, map[uint64]string{
+//		uint64(os.O_WRONLY): "O_WRONLY",
+//		uint64(os.O_RDWR):   "O_RDWR",
+//		uint64(os.O_CREATE): "O_CREATE",
+//	})
+//
+// prints os.O_RDWR|os.O_CREATE as int(O_RDWR|O_CREATE) instead of int(66).
+// Names for more than one bit, like masks, are matched before single bits, and
+// bits that have no name are printed in hex, e.g. O_RDWR|0x8000. A name for 0
+// is used when no bits are set. Registering the type again replaces its names.
+//
+// It applies to every value of that type, so it's best used with named types.
+// Untyped constants, like the ones in the example, are ints, so registering
+// them makes q print every int as flags.
+func RegisterFlags(zero interface{}, names map[uint64]string) {
+	flags := make([]flag, 0, len(names))
+	for b, name := range names {
+		flags = append(flags, flag{b, name})
+	}
+	// Match the flags with the most bits first, so masks win over the bits in
+	// them.
+	sort.Slice(flags, func(i, j int) bool {
+		ci, cj := bits.OnesCount64(flags[i].bits), bits.OnesCount64(flags[j].bits)
+		if ci != cj {
+			return ci > cj
+		}
+		return flags[i].bits < flags[j].bits
+	})
+
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	if std.opts.flags == nil {
+		std.opts.flags = make(map[reflect.Type][]flag)
+	}
+	std.opts.flags[reflect.TypeOf(zero)] = flags
+}
+
+// isFlags returns true if v is an integer of a type registered with
+// RegisterFlags().
+func (p *valuePrinter) isFlags(v reflect.Value) bool {
+	if p.opts.flags == nil {
+		return false
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		_, ok := p.opts.flags[v.Type()]
+		return ok
+	}
+	return false
+}
+
+// printFlags prints an integer as the names of the flags set in it.
+func (p *valuePrinter) printFlags(v reflect.Value, showType bool) {
+	var n uint64
+	if v.Kind() >= reflect.Int && v.Kind() <= reflect.Int64 {
+		n = uint64(v.Int())
+	} else {
+		n = v.Uint()
+	}
+
+	if showType {
+		io.WriteString(p, p.typeName(v.Type()))
+		writeByte(p, '(')
+	}
+	io.WriteString(p, flagNames(n, p.opts.flags[v.Type()]))
+	if showType {
+		writeByte(p, ')')
+	}
+}
+
+// flagNames returns the names of the flags set in n, separated by |, in order
+// of their value. Bits without a name are printed in hex.
+func flagNames(n uint64, flags []flag) string {
+	var set []flag
+	rest := n
+	for _, f := range flags {
+		if f.bits != 0 && rest&f.bits == f.bits {
+			set = append(set, f)
+			rest &^= f.bits
+		}
+	}
+
+	if len(set) == 0 && rest == 0 {
+		for _, f := range flags {
+			if f.bits == 0 {
+				return f.name
+			}
+		}
+		return "0"
+	}
+
+	sort.Slice(set, func(i, j int) bool { return set[i].bits < set[j].bits })
+	names := make([]string, 0, len(set)+1)
+	for _, f := range set {
+		names = append(names, f.name)
+	}
+	if rest != 0 {
+		names = append(names, fmt.Sprintf("%#x", rest))
+	}
+	return strings.Join(names, "|")
+}
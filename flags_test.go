@@ -0,0 +1,54 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"reflect"
+	"testing"
+)
+
+type openFlag int
+
+// TestFormatFlags verifies that formatValue() prints registered flag types as
+// the names of the flags set in them.
+func TestFormatFlags(t *testing.T) {
+	names := map[uint64]string{
+		0x0:  "O_RDONLY",
+		0x1:  "O_WRONLY",
+		0x2:  "O_RDWR",
+		0x3:  "O_ACCMODE",
+		0x40: "O_CREATE",
+		0x80: "O_EXCL",
+	}
+	RegisterFlags(openFlag(0), names)
+	defer func() {
+		std.mu.Lock()
+		delete(std.opts.flags, reflect.TypeOf(openFlag(0)))
+		std.mu.Unlock()
+	}()
+
+	std.mu.Lock()
+	opts := formatOptions{flags: std.opts.flags}
+	std.mu.Unlock()
+
+	testCases := []struct {
+		id   int
+		arg  interface{}
+		want string
+	}{
+		{1, openFlag(0x42), "q.openFlag(O_RDWR|O_CREATE)"},
+		{2, openFlag(0), "q.openFlag(O_RDONLY)"},
+		{3, openFlag(0xc3), "q.openFlag(O_ACCMODE|O_CREATE|O_EXCL)"},
+		{4, openFlag(0x8042), "q.openFlag(O_RDWR|O_CREATE|0x8000)"},
+		{5, []openFlag{0x41}, "[]q.openFlag{O_WRONLY|O_CREATE}"},
+		{6, 0x42, "int(66)"},
+	}
+
+	for _, tc := range testCases {
+		if got := formatValue(tc.arg, opts); got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:  %s\nwant: %s", tc.id, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,644 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+//
+// The value printer and the indenting writer in this file are derived from
+// github.com/kr/pretty and github.com/kr/text, which carry this notice:
+//
+// Copyright 2012 Keith Rarick
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package q
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"math/cmplx"
+	"reflect"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// The value printer below is derived from the one in github.com/kr/pretty (see
+// the notice above), and produces the same layout. q has its own copy so it can
+// decide how particular types are rendered, e.g. sorting map keys so output is
+// deterministic.
+
+// defaultMaxDepth is how deep the printer will descend into nested values,
+// unless a call says otherwise. See WithMaxDepth().
+const defaultMaxDepth = 10
+
+// formatOptions control how values are printed. The zero value gives q's
+// default output.
+type formatOptions struct {
+	showSecrets bool // don't redact sensitive values. see SetRedaction().
+	showAddr    bool // print pointers as address->value. see SetShowPointerAddr().
+	sortFields  bool // print struct fields sorted by name. see SetSortFields().
+	compact     bool // print structs on one line. see SetCompactStructs().
+	hexDump     bool // print byte slices as hexdumps. see SetHexDump().
+	hexWidth    int  // bytes per row of a hexdump. 0 means 16. see SetHexDumpWidth().
+	utf8Bytes   bool // print byte slices that are valid UTF-8 as strings. see SetBytesAsStringIfUTF8().
+
+	complexForm ComplexFormat // how to print complex numbers. see SetComplexFormat().
+	complexPrec int           // digits after the decimal point in complex numbers, plus 1. 0 means as many as needed.
+	maxDepth    int           // how deep to descend into nested values. 0 means 10, <0 no limit. see WithMaxDepth().
+
+	formatterVerb string // verb for printing fmt.Formatters. see SetFormatterVerb().
+
+	now func() time.Time // the current time, e.g. for how long until a deadline. nil means time.Now.
+
+	baseColor Color // color of the value being printed, if it's colored. resumed after JSON keys and strings.
+	anomalies *int  // counts the NaNs and ±Infs printed, if not nil. see SetRunSummary().
+
+	flags       map[reflect.Type][]flag             // types printed as named flags. see RegisterFlags().
+	typeAliases map[reflect.Type]string             // short names for types. see RegisterTypeAlias().
+	timeZones   []*time.Location                    // print time.Time in each of these zones. see SetTimeZones().
+	colorRules  []func(v interface{}) (Color, bool) // pick the color of top-level values. see AddColorRule().
+}
+
+// formatValue pretty-prints the given value. Strings at the top level are
+// printed without quotes. If a method of v, like Error() or Unwrap(), panics
+// while v is printed, the panic is printed instead of v.
+func formatValue(v interface{}, opts formatOptions) (s string) {
+	defer func() {
+		if r := recover(); r != nil {
+			s = fmt.Sprintf("<panic formatting %T: %v>", v, r)
+		}
+	}()
+
+	var buf bytes.Buffer
+	w := newTabWriter(&buf)
+	p := &valuePrinter{Writer: w, tw: w, visited: make(map[visit]int), opts: opts}
+	p.printValue(reflect.ValueOf(v), true, false)
+	w.Flush()
+	return buf.String()
+}
+
+// newTabWriter returns a tabwriter with the settings used for all q output.
+func newTabWriter(w io.Writer) *tabwriter.Writer {
+	return tabwriter.NewWriter(w, 4, 4, 1, ' ', 0)
+}
+
+// valuePrinter writes the pretty-printed form of a value. Nested values are
+// written through an indentWriter so they line up under their parent.
+type valuePrinter struct {
+	io.Writer
+	tw      *tabwriter.Writer
+	visited map[visit]int // protects against cyclic references
+	depth   int
+	opts    formatOptions
+	oneLine bool // print everything on one line, e.g. inside a compact struct
+}
+
+// visit identifies a value the printer has already seen.
+type visit struct {
+	v   uintptr
+	typ reflect.Type
+}
+
+// indent returns a copy of the printer that writes one level deeper.
+func (p *valuePrinter) indent() *valuePrinter {
+	q := *p
+	q.tw = newTabWriter(p.Writer)
+	q.Writer = &indentWriter{w: q.tw, pre: []byte{'\t'}, bol: true}
+	return &q
+}
+
+// sub returns a printer that shares this printer's state but writes to w.
+func (p *valuePrinter) sub(w io.Writer) *valuePrinter {
+	q := *p
+	q.tw = newTabWriter(w)
+	q.Writer = q.tw
+	return &q
+}
+
+func (p *valuePrinter) printInline(v reflect.Value, x interface{}, showType bool) {
+	if showType {
+		io.WriteString(p, p.typeName(v.Type()))
+		fmt.Fprintf(p, "(%#v)", x)
+	} else {
+		fmt.Fprintf(p, "%#v", x)
+	}
+}
+
+// isAnomaly returns true if f is NaN or ±Inf, which is usually the result of a
+// bug.
+func isAnomaly(f float64) bool {
+	return math.IsNaN(f) || math.IsInf(f, 0)
+}
+
+// printComplex prints a complex number in the form set with
+// SetComplexFormat().
+func (p *valuePrinter) printComplex(v reflect.Value) {
+	c := v.Complex()
+	if p.opts.complexForm == ComplexRectangular && p.opts.complexPrec == 0 {
+		fmt.Fprintf(p, "%#v", c)
+		return
+	}
+
+	bitSize := 128
+	if v.Kind() == reflect.Complex64 {
+		bitSize = 64
+	}
+	format, prec := byte('g'), -1
+	if p.opts.complexPrec > 0 {
+		format, prec = 'f', p.opts.complexPrec-1
+	}
+
+	if p.opts.complexForm == ComplexRectangular {
+		io.WriteString(p, strconv.FormatComplex(c, format, prec, bitSize))
+		return
+	}
+	r, theta := cmplx.Polar(c)
+	fmt.Fprintf(p, "(%s∠%s)",
+		strconv.FormatFloat(r, format, prec, bitSize/2),
+		strconv.FormatFloat(theta, format, prec, bitSize/2))
+}
+
+// depthExceeded returns true if values at the given depth are too deep to
+// print. See WithMaxDepth().
+func (p *valuePrinter) depthExceeded(depth int) bool {
+	max := p.opts.maxDepth
+	return (max == 0 && depth > defaultMaxDepth) || (max > 0 && depth > max)
+}
+
+func (p *valuePrinter) printValue(v reflect.Value, showType, quote bool) {
+	if p.depthExceeded(p.depth) {
+		io.WriteString(p, "!%v(DEPTH EXCEEDED)")
+		return
+	}
+
+	if p.printSpecial(v, showType) {
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		p.printInline(v, v.Bool(), showType)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		p.printInline(v, v.Int(), showType)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		p.printInline(v, v.Uint(), showType)
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if p.opts.anomalies != nil && isAnomaly(f) {
+			*p.opts.anomalies++
+		}
+		p.printInline(v, f, showType)
+	case reflect.Complex64, reflect.Complex128:
+		p.printComplex(v)
+	case reflect.String:
+		p.fmtString(v.String(), quote)
+	case reflect.Map:
+		p.printMap(v, showType)
+	case reflect.Struct:
+		p.printStruct(v, showType)
+	case reflect.Interface:
+		switch e := v.Elem(); {
+		case e.Kind() == reflect.Invalid:
+			io.WriteString(p, "nil")
+		case e.IsValid():
+			pp := *p
+			pp.depth++
+			pp.printValue(e, showType, true)
+		default:
+			io.WriteString(p, p.typeName(v.Type()))
+			io.WriteString(p, "(nil)")
+		}
+	case reflect.Array, reflect.Slice:
+		p.printSlice(v, showType)
+	case reflect.Ptr:
+		e := v.Elem()
+		if !e.IsValid() {
+			writeByte(p, '(')
+			io.WriteString(p, p.typeName(v.Type()))
+			io.WriteString(p, ")(nil)")
+		} else if e.Kind() != reflect.Struct && p.isCycle(v) {
+			// Structs are checked by printStruct(), which shows their type.
+			p.fmtString(p.typeName(v.Type())+"(CYCLIC REFERENCE)", false)
+		} else {
+			if e.Kind() != reflect.Struct {
+				defer p.leave(v)
+			}
+			pp := *p
+			pp.depth++
+			if p.opts.showAddr {
+				fmt.Fprintf(pp, "%#x->", v.Pointer())
+			} else {
+				writeByte(pp, '&')
+			}
+			pp.printValue(e, true, true)
+		}
+	case reflect.Chan:
+		x := v.Pointer()
+		if showType {
+			writeByte(p, '(')
+			io.WriteString(p, p.typeName(v.Type()))
+			fmt.Fprintf(p, ")(%#v)", x)
+		} else {
+			fmt.Fprintf(p, "%#v", x)
+		}
+	case reflect.Func:
+		io.WriteString(p, p.typeName(v.Type()))
+		io.WriteString(p, " {...}")
+	case reflect.UnsafePointer:
+		p.printInline(v, v.Pointer(), showType)
+	case reflect.Invalid:
+		io.WriteString(p, "nil")
+	}
+}
+
+// printMap writes a map with its keys in a stable order. See sortedMapKeys.
+func (p *valuePrinter) printMap(v reflect.Value, showType bool) {
+	t := v.Type()
+	if showType {
+		io.WriteString(p, p.typeName(t))
+	}
+	writeByte(p, '{')
+	if nonzero(v) && p.isCycle(v) {
+		io.WriteString(p, "(CYCLIC REFERENCE)}")
+		return
+	}
+	if nonzero(v) {
+		defer p.leave(v)
+		expand := p.expand(t)
+		pp := p
+		if expand {
+			writeByte(p, '\n')
+			pp = p.indent()
+		}
+		keys := p.sortedMapKeys(v)
+		for i, k := range keys {
+			io.WriteString(pp, k.text)
+			writeByte(pp, ':')
+			if expand {
+				writeByte(pp, '\t')
+			}
+			showTypeInMap := t.Elem().Kind() == reflect.Interface
+			pp.printValue(k.elem, showTypeInMap, true)
+			if expand {
+				io.WriteString(pp, ",\n")
+			} else if i < len(keys)-1 {
+				io.WriteString(pp, ", ")
+			}
+		}
+		if expand {
+			pp.tw.Flush()
+		}
+	}
+	writeByte(p, '}')
+}
+
+// mapKey is a map key along with its pretty-printed form and its element.
+// The element is kept here because a NaN key can't be looked up with MapIndex.
+type mapKey struct {
+	v    reflect.Value
+	text string
+	elem reflect.Value
+
+	// elemText is the pretty-printed element, only set for NaN keys. It's
+	// what orders NaN keys among themselves, since their text is the same.
+	elemText string
+}
+
+// sortedMapKeys returns the keys of the map v, each formatted the same way as
+// any other value. Keys with a natural order (numbers and strings) are sorted
+// by value. All other keys, e.g. structs and pointers, are sorted by their
+// formatted text, so that the output doesn't change from one call to the next.
+// NaN keys, which aren't ordered, go first.
+func (p *valuePrinter) sortedMapKeys(v reflect.Value) []mapKey {
+	keys := make([]mapKey, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		k := mapKey{v: iter.Key(), elem: iter.Value()}
+		k.text = p.sprint(k.v)
+		if isNaN(k.v) {
+			k.elemText = p.sprint(k.elem)
+		}
+		keys = append(keys, k)
+	}
+
+	sort.Sort(byMapKey(keys))
+	return keys
+}
+
+// sprint returns v formatted the same way printValue would write it.
+func (p *valuePrinter) sprint(v reflect.Value) string {
+	var buf bytes.Buffer
+	pp := p.sub(&buf)
+	pp.printValue(v, false, true)
+	pp.tw.Flush()
+	return buf.String()
+}
+
+// isNaN returns true if v is a float that is NaN.
+func isNaN(v reflect.Value) bool {
+	k := v.Kind()
+	return (k == reflect.Float32 || k == reflect.Float64) && math.IsNaN(v.Float())
+}
+
+// byMapKey sorts map keys by value if their kind has a natural order, or by their
+// formatted text if it doesn't.
+type byMapKey []mapKey
+
+func (k byMapKey) Len() int      { return len(k) }
+func (k byMapKey) Swap(i, j int) { k[i], k[j] = k[j], k[i] }
+func (k byMapKey) Less(i, j int) bool {
+	a, b := k[i].v, k[j].v
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() < b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return a.Uint() < b.Uint()
+	case reflect.Float32, reflect.Float64:
+		if nanA, nanB := isNaN(a), isNaN(b); nanA || nanB {
+			return nanA && (!nanB || k[i].elemText < k[j].elemText)
+		}
+		return a.Float() < b.Float()
+	case reflect.String:
+		return a.String() < b.String()
+	}
+	return k[i].text < k[j].text
+}
+
+// isCycle returns true if the map, slice, or pointer v is already being
+// printed further up, i.e. it contains itself. If not, it marks v as being
+// printed, until leave is called, so the same value printed twice side by side
+// isn't mistaken for a cycle. Without this, a map that contains itself would
+// be printed until the stack overflowed when there's no depth limit.
+func (p *valuePrinter) isCycle(v reflect.Value) bool {
+	vis := visit{v.Pointer(), v.Type()}
+	if _, ok := p.visited[vis]; ok {
+		return true
+	}
+	p.visited[vis] = p.depth
+	return false
+}
+
+// leave unmarks v, which isCycle marked as being printed.
+func (p *valuePrinter) leave(v reflect.Value) {
+	delete(p.visited, visit{v.Pointer(), v.Type()})
+}
+
+func (p *valuePrinter) printStruct(v reflect.Value, showType bool) {
+	t := v.Type()
+	if v.CanAddr() {
+		addr := v.UnsafeAddr()
+		vis := visit{addr, t}
+		if vd, ok := p.visited[vis]; ok && vd < p.depth {
+			p.fmtString(p.typeName(t)+"{(CYCLIC REFERENCE)}", false)
+			return // don't print v again
+		}
+		p.visited[vis] = p.depth
+	}
+
+	if p.opts.compact && !p.oneLine {
+		pp := *p
+		pp.oneLine = true
+		p = &pp
+	}
+
+	if showType {
+		io.WriteString(p, p.typeName(t))
+	}
+	writeByte(p, '{')
+	if nonzero(v) {
+		expand := p.expand(t)
+		pp := p
+		if expand {
+			writeByte(p, '\n')
+			pp = p.indent()
+		}
+		fields := p.fieldOrder(t)
+		for n, i := range fields {
+			showTypeInStruct := true
+			if f := t.Field(i); f.Name != "" {
+				io.WriteString(pp, f.Name)
+				writeByte(pp, ':')
+				if expand {
+					writeByte(pp, '\t')
+				}
+				showTypeInStruct = labelType(f.Type)
+			}
+			pp.printValue(getField(v, i), showTypeInStruct, true)
+			if expand {
+				io.WriteString(pp, ",\n")
+			} else if n < len(fields)-1 {
+				io.WriteString(pp, ", ")
+			}
+		}
+		if expand {
+			pp.tw.Flush()
+		}
+	}
+	writeByte(p, '}')
+}
+
+// fieldOrder returns the indexes of t's fields in the order they should be
+// printed: declaration order, or sorted by name if sortFields is set.
+func (p *valuePrinter) fieldOrder(t reflect.Type) []int {
+	fields := make([]int, t.NumField())
+	for i := range fields {
+		fields[i] = i
+	}
+	if p.opts.sortFields {
+		sort.SliceStable(fields, func(i, j int) bool {
+			return t.Field(fields[i]).Name < t.Field(fields[j]).Name
+		})
+	}
+	return fields
+}
+
+func (p *valuePrinter) printSlice(v reflect.Value, showType bool) {
+	t := v.Type()
+	if showType {
+		io.WriteString(p, p.typeName(t))
+	}
+	if v.Kind() == reflect.Slice && v.IsNil() {
+		if showType {
+			io.WriteString(p, "(nil)")
+		} else {
+			io.WriteString(p, "nil")
+		}
+		return
+	}
+	if v.Kind() == reflect.Slice && v.Len() > 0 {
+		if p.isCycle(v) {
+			io.WriteString(p, "{(CYCLIC REFERENCE)}")
+			return
+		}
+		defer p.leave(v)
+	}
+	writeByte(p, '{')
+	expand := p.expand(t)
+	pp := p
+	if expand {
+		writeByte(p, '\n')
+		pp = p.indent()
+	}
+	for i := 0; i < v.Len(); i++ {
+		showTypeInSlice := t.Elem().Kind() == reflect.Interface
+		pp.printValue(v.Index(i), showTypeInSlice, true)
+		if expand {
+			io.WriteString(pp, ",\n")
+		} else if i < v.Len()-1 {
+			io.WriteString(pp, ", ")
+		}
+	}
+	if expand {
+		pp.tw.Flush()
+	}
+	writeByte(p, '}')
+}
+
+func (p *valuePrinter) fmtString(s string, quote bool) {
+	if quote {
+		s = strconv.Quote(s)
+	}
+	io.WriteString(p, s)
+}
+
+// expand returns true if values of type t should be printed on several lines.
+func (p *valuePrinter) expand(t reflect.Type) bool {
+	return !p.oneLine && !canInline(t)
+}
+
+// canInline returns true if values of type t can be printed on a single line.
+func canInline(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Map:
+		return !canExpand(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if canExpand(t.Field(i).Type) {
+				return false
+			}
+		}
+		return true
+	case reflect.Interface:
+		return false
+	case reflect.Array, reflect.Slice:
+		return !canExpand(t.Elem())
+	case reflect.Ptr:
+		return false
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return false
+	}
+	return true
+}
+
+// canExpand returns true if values of type t may span multiple lines.
+func canExpand(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Map, reflect.Struct,
+		reflect.Interface, reflect.Array, reflect.Slice,
+		reflect.Ptr:
+		return true
+	}
+	return false
+}
+
+// labelType returns true if the type name should be printed before a struct
+// field of type t.
+func labelType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Interface, reflect.Struct:
+		return true
+	}
+	return false
+}
+
+// nonzero returns true if v is not the zero value of its type.
+func nonzero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() != 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() != 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() != 0
+	case reflect.Complex64, reflect.Complex128:
+		return v.Complex() != complex(0, 0)
+	case reflect.String:
+		return v.String() != ""
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if nonzero(getField(v, i)) {
+				return true
+			}
+		}
+		return false
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if nonzero(v.Index(i)) {
+				return true
+			}
+		}
+		return false
+	case reflect.Map, reflect.Interface, reflect.Slice, reflect.Ptr, reflect.Chan, reflect.Func:
+		return !v.IsNil()
+	case reflect.UnsafePointer:
+		return v.Pointer() != 0
+	}
+	return true
+}
+
+// getField returns the i'th field of the struct v. Interface fields are
+// unwrapped to their concrete value.
+func getField(v reflect.Value, i int) reflect.Value {
+	val := v.Field(i)
+	if val.Kind() == reflect.Interface && !val.IsNil() {
+		val = val.Elem()
+	}
+	return val
+}
+
+func writeByte(w io.Writer, b byte) {
+	w.Write([]byte{b})
+}
+
+// indentWriter writes pre at the beginning of every line written through it.
+type indentWriter struct {
+	w   io.Writer
+	pre []byte
+	bol bool // true if the next byte written begins a line
+}
+
+func (w *indentWriter) Write(p []byte) (n int, err error) {
+	for _, c := range p {
+		if w.bol {
+			if _, err = w.w.Write(w.pre); err != nil {
+				return n, err
+			}
+		}
+		if _, err = w.w.Write([]byte{c}); err != nil {
+			return n, err
+		}
+		n++
+		w.bol = c == '\n'
+	}
+	return n, nil
+}
@@ -0,0 +1,874 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type point struct{ x, y int }
+
+// TestFormatMapKeys verifies that formatValue() prints map keys in a stable
+// order, including keys that are structs or pointers.
+func TestFormatMapKeys(t *testing.T) {
+	testCases := []struct {
+		id   int
+		arg  interface{}
+		want string
+	}{
+		{
+			id:   1,
+			arg:  map[int]string{3: "c", 1: "a", 2: "b"},
+			want: `map[int]string{1:"a", 2:"b", 3:"c"}`,
+		},
+		{
+			id:   2,
+			arg:  map[string]int{"b": 2, "c": 3, "a": 1},
+			want: `map[string]int{"a":1, "b":2, "c":3}`,
+		},
+		{
+			id: 3,
+			arg: map[point]string{
+				{x: 2, y: 1}: "b",
+				{x: 1, y: 2}: "a",
+				{x: 1, y: 1}: "c",
+			},
+			want: `map[q.point]string{{x:1, y:1}:"c", {x:1, y:2}:"a", {x:2, y:1}:"b"}`,
+		},
+		{
+			id: 4,
+			arg: map[*point]int{
+				{x: 5, y: 5}: 2,
+				{x: 3, y: 4}: 1,
+			},
+			want: `map[*q.point]int{&q.point{x:3, y:4}:1, &q.point{x:5, y:5}:2}`,
+		},
+		{
+			id: 5,
+			arg: map[point][]int{
+				{x: 9, y: 9}: {9},
+				{x: 0, y: 0}: {0},
+			},
+			want: "map[q.point][]int{\n    {x:9, y:9}: {9},\n    {}:         {0},\n}",
+		},
+		{
+			id:   6,
+			arg:  map[float64]int{2: 4, math.NaN(): 3, -1: 1, math.NaN(): 2},
+			want: `map[float64]int{NaN:2, NaN:3, -1:1, 2:4}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		// Map iteration order is random, so format each map several times.
+		for i := 0; i < 10; i++ {
+			if got := formatValue(tc.arg, formatOptions{}); got != tc.want {
+				t.Fatalf("\nTEST %d\ngot:  %s\nwant: %s", tc.id, got, tc.want)
+			}
+		}
+	}
+}
+
+// TestFormatOpaqueTypes verifies that formatValue() doesn't print the runtime
+// internals of timers and tickers.
+func TestFormatOpaqueTypes(t *testing.T) {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	testCases := []struct {
+		id   int
+		arg  interface{}
+		want string
+	}{
+		{id: 1, arg: timer, want: "&time.Timer{...}"},
+		{id: 2, arg: ticker, want: "&time.Ticker{...}"},
+		{id: 3, arg: (*time.Timer)(nil), want: "(*time.Timer)(nil)"},
+		{
+			id: 4,
+			arg: struct {
+				T *time.Ticker
+				N int
+			}{ticker, 1},
+			want: "struct { T *time.Ticker; N int }{\n    T:  &time.Ticker{...},\n    N:  1,\n}",
+		},
+	}
+
+	for _, tc := range testCases {
+		if got := formatValue(tc.arg, formatOptions{}); got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:  %s\nwant: %s", tc.id, got, tc.want)
+		}
+	}
+}
+
+// TestFormatMultiValueMaps verifies that formatValue() prints url.Values and
+// http.Header as sorted "Key: v1, v2" lines, and redacts sensitive headers.
+func TestFormatMultiValueMaps(t *testing.T) {
+	header := http.Header{}
+	header.Add("Accept", "text/html")
+	header.Add("Accept", "application/json")
+	header.Add("Authorization", "Bearer hunter2")
+	header["x-raw-key"] = []string{"raw"}
+
+	testCases := []struct {
+		id   int
+		arg  interface{}
+		opts formatOptions
+		want string
+	}{
+		{
+			id:   1,
+			arg:  url.Values{"q": {"golang"}, "page": {"1", "2"}},
+			want: "url.Values{\n    page: 1, 2\n    q:    golang\n}",
+		},
+		{
+			id:   2,
+			arg:  header,
+			want: "http.Header{\n    Accept:        text/html, application/json\n    Authorization: <redacted>\n    X-Raw-Key:     raw\n}",
+		},
+		{
+			id:   3,
+			arg:  http.Header{"Authorization": {"Bearer hunter2"}},
+			opts: formatOptions{showSecrets: true},
+			want: "http.Header{\n    Authorization: Bearer hunter2\n}",
+		},
+		{
+			id:   4,
+			arg:  url.Values{},
+			want: "url.Values{}",
+		},
+		{
+			id:   5,
+			arg:  map[string][]string{"b": {"2"}, "a": {"1"}},
+			want: "map[string][]string{\n    \"a\": {\"1\"},\n    \"b\": {\"2\"},\n}",
+		},
+	}
+
+	for _, tc := range testCases {
+		if got := formatValue(tc.arg, tc.opts); got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:  %s\nwant: %s", tc.id, got, tc.want)
+		}
+	}
+}
+
+// joinedErrors is an error that wraps several errors, like the ones returned
+// by errors.Join(), which isn't available before Go 1.20.
+type joinedErrors []error
+
+func (e joinedErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (e joinedErrors) Unwrap() []error {
+	return e
+}
+
+// TestFormatErrors verifies that formatValue() prints slices of errors, and
+// errors that wrap several errors, with each error on its own line.
+func TestFormatErrors(t *testing.T) {
+	errA := errors.New("a failed")
+	errB := errors.New("b failed")
+	errC := errors.New("c failed")
+
+	testCases := []struct {
+		id   int
+		arg  interface{}
+		want string
+	}{
+		{
+			id:   1,
+			arg:  []error{errA, nil, errB},
+			want: "[]error{\n    \"a failed\",\n    nil,\n    \"b failed\",\n}",
+		},
+		{
+			id:   2,
+			arg:  &joinedErrors{errA, errB},
+			want: "*q.joinedErrors{\n    \"a failed\",\n    \"b failed\",\n}",
+		},
+		{
+			id:  3,
+			arg: []error{errA, joinedErrors{errB, errC}},
+			want: "[]error{\n    \"a failed\",\n    q.joinedErrors{\n        \"b failed\",\n" +
+				"        \"c failed\",\n    },\n}",
+		},
+		{
+			id:   4,
+			arg:  []error{},
+			want: "[]error{}",
+		},
+		{
+			id:   5,
+			arg:  []error(nil),
+			want: "[]error(nil)",
+		},
+	}
+
+	for _, tc := range testCases {
+		if got := formatValue(tc.arg, formatOptions{}); got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:  %s\nwant: %s", tc.id, got, tc.want)
+		}
+	}
+}
+
+// TestFormatPointerAddr verifies that formatValue() prints pointer addresses
+// when showAddr is set, and still detects cyclic references.
+func TestFormatPointerAddr(t *testing.T) {
+	type node struct {
+		Next *node
+		N    int
+	}
+
+	p := &point{x: 1, y: 2}
+	n := &node{N: 1}
+	n.Next = n
+
+	testCases := []struct {
+		id   int
+		arg  interface{}
+		want string
+	}{
+		{
+			id:   1,
+			arg:  p,
+			want: fmt.Sprintf("%p->q.point{x:1, y:2}", p),
+		},
+		{
+			id:   2,
+			arg:  []*point{p, p},
+			want: fmt.Sprintf("[]*q.point{\n    %[1]p->q.point{x:1, y:2},\n    %[1]p->q.point{x:1, y:2},\n}", p),
+		},
+		{
+			id:   3,
+			arg:  (*point)(nil),
+			want: "(*q.point)(nil)",
+		},
+		{
+			id:   4,
+			arg:  n,
+			want: fmt.Sprintf("%[1]p->q.node{\n    Next: %[1]p->q.node{(CYCLIC REFERENCE)},\n    N:    1,\n}", n),
+		},
+	}
+
+	for _, tc := range testCases {
+		got := formatValue(tc.arg, formatOptions{showAddr: true})
+		if got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:  %s\nwant: %s", tc.id, got, tc.want)
+		}
+	}
+}
+
+// TestFormatRawMessage verifies that formatValue() prints valid JSON in a
+// json.RawMessage as indented JSON, and anything else as bytes.
+func TestFormatRawMessage(t *testing.T) {
+	// Depending on the Go version, json.RawMessage may be an alias of another
+	// type.
+	typeName := reflect.TypeOf(json.RawMessage(nil)).String()
+
+	testCases := []struct {
+		id   int
+		arg  interface{}
+		want string
+	}{
+		{
+			id:   1,
+			arg:  json.RawMessage(`{"id":5,"tags":["a","b"]}`),
+			want: typeName + "({\n    \"id\": 5,\n    \"tags\": [\n        \"a\",\n        \"b\"\n    ]\n})",
+		},
+		{
+			id:   2,
+			arg:  json.RawMessage(`42`),
+			want: typeName + "(42)",
+		},
+		{
+			id:   3,
+			arg:  json.RawMessage(`{"id":`),
+			want: typeName + "{0x7b, 0x22, 0x69, 0x64, 0x22, 0x3a}",
+		},
+		{
+			id:   4,
+			arg:  json.RawMessage(nil),
+			want: typeName + "(nil)",
+		},
+		{
+			id: 5,
+			arg: struct {
+				Body json.RawMessage
+			}{json.RawMessage(`{"ok":true}`)},
+			want: "struct { Body " + typeName + " }{\n    Body: {\n        \"ok\": true\n    },\n}",
+		},
+	}
+
+	for _, tc := range testCases {
+		if got := formatValue(tc.arg, formatOptions{}); got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:  %s\nwant: %s", tc.id, got, tc.want)
+		}
+	}
+}
+
+// TestFormatRawMessageOptions verifies that formatValue() colors the keys and
+// strings of a json.RawMessage when the value is colored, and cuts off JSON
+// nested deeper than the max depth.
+func TestFormatRawMessageOptions(t *testing.T) {
+	msg := json.RawMessage(`{"id":5,"name":"a\"b","tags":[{"x":null}],"ok":true}`)
+	reset := string(endColor) + string(cyan)
+	key := func(k string) string { return string(jsonKeyColor) + `"` + k + `"` + reset }
+	typeName := reflect.TypeOf(msg).String()
+	str := func(v string) string { return string(jsonStringColor) + `"` + v + `"` + reset }
+
+	testCases := []struct {
+		id   int
+		arg  interface{}
+		opts formatOptions
+		want string
+	}{
+		{
+			id:   1,
+			arg:  msg,
+			opts: formatOptions{baseColor: cyan},
+			want: typeName + "({\n    " + key("id") + ": 5,\n    " + key("name") + ": " + str(`a\"b`) + ",\n    " +
+				key("tags") + ": [\n        {\n            " + key("x") + ": null\n        }\n    ],\n    " +
+				key("ok") + ": true\n})",
+		},
+		{
+			id:   2,
+			arg:  msg,
+			opts: formatOptions{maxDepth: 1},
+			want: typeName + "({\n    \"id\": 5,\n    \"name\": \"a\\\"b\",\n    \"tags\": [\n        !%v(DEPTH EXCEEDED)\n    ],\n" +
+				"    \"ok\": true\n})",
+		},
+		{
+			id:   3,
+			arg:  &msg,
+			opts: formatOptions{maxDepth: 1},
+			want: "&" + typeName + "({\n    \"id\": !%v(DEPTH EXCEEDED),\n    \"name\": !%v(DEPTH EXCEEDED),\n" +
+				"    \"tags\": !%v(DEPTH EXCEEDED),\n    \"ok\": !%v(DEPTH EXCEEDED)\n})",
+		},
+		{
+			id:   4,
+			arg:  struct{ Body json.RawMessage }{json.RawMessage(`{"a":[],"b":{}}`)},
+			opts: formatOptions{compact: true},
+			want: "struct { Body " + typeName + ` }{Body:{"a":[],"b":{}}}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		if got := formatValue(tc.arg, tc.opts); got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:  %q\nwant: %q", tc.id, got, tc.want)
+		}
+	}
+}
+
+// TestFormatSortFields verifies that formatValue() prints struct fields sorted
+// by name when sortFields is set.
+func TestFormatSortFields(t *testing.T) {
+	type user struct {
+		Name  string
+		Email string
+		Age   int
+	}
+
+	testCases := []struct {
+		id   int
+		arg  interface{}
+		opts formatOptions
+		want string
+	}{
+		{
+			id:   1,
+			arg:  user{"bob", "bob@example.com", 30},
+			want: "q.user{Name:\"bob\", Email:\"bob@example.com\", Age:30}",
+		},
+		{
+			id:   2,
+			arg:  user{"bob", "bob@example.com", 30},
+			opts: formatOptions{sortFields: true},
+			want: "q.user{Age:30, Email:\"bob@example.com\", Name:\"bob\"}",
+		},
+		{
+			id: 3,
+			arg: struct {
+				Z []int
+				A map[string]int
+			}{[]int{1}, map[string]int{"k": 1}},
+			opts: formatOptions{sortFields: true},
+			want: "struct { Z []int; A map[string]int }{\n    A:  {\"k\":1},\n    Z:  {1},\n}",
+		},
+	}
+
+	for _, tc := range testCases {
+		if got := formatValue(tc.arg, tc.opts); got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:  %s\nwant: %s", tc.id, got, tc.want)
+		}
+	}
+}
+
+// TestFormatCompactStructs verifies that formatValue() prints structs, and
+// everything in them, on one line when compact is set.
+func TestFormatCompactStructs(t *testing.T) {
+	type addr struct {
+		City string
+		Zip  *int
+	}
+	type user struct {
+		ID   int
+		Addr addr
+		Tags []string
+		Meta json.RawMessage
+	}
+
+	zip := 75001
+	u := user{
+		ID:   5,
+		Addr: addr{City: "Paris", Zip: &zip},
+		Tags: []string{"a", "b"},
+		Meta: json.RawMessage(`{"x": 1}`),
+	}
+	typeName := reflect.TypeOf(json.RawMessage(nil)).String()
+
+	testCases := []struct {
+		id   int
+		arg  interface{}
+		want string
+	}{
+		{
+			id:   1,
+			arg:  u,
+			want: `q.user{ID:5, Addr:q.addr{City:"Paris", Zip:&int(75001)}, Tags:{"a", "b"}, Meta:{"x":1}}`,
+		},
+		{
+			id:   2,
+			arg:  []user{u},
+			want: "[]q.user{\n    {ID:5, Addr:q.addr{City:\"Paris\", Zip:&int(75001)}, Tags:{\"a\", \"b\"}, Meta:{\"x\":1}},\n}",
+		},
+		{
+			id:   3,
+			arg:  map[string][]int{"a": {1}},
+			want: "map[string][]int{\n    \"a\": {1},\n}",
+		},
+		{
+			id:   4,
+			arg:  json.RawMessage(`{"x": 1}`),
+			want: typeName + "({\n    \"x\": 1\n})",
+		},
+	}
+
+	for _, tc := range testCases {
+		if got := formatValue(tc.arg, formatOptions{compact: true}); got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:  %s\nwant: %s", tc.id, got, tc.want)
+		}
+	}
+}
+
+// TestFormatTime verifies that formatValue() prints a time.Time in its own
+// location, or in each of the configured zones.
+func TestFormatTime(t *testing.T) {
+	pst := time.FixedZone("PST", -8*60*60)
+	tm := time.Date(2024, 3, 10, 17, 4, 5, 0, time.UTC)
+
+	testCases := []struct {
+		id    int
+		arg   interface{}
+		zones []*time.Location
+		want  string
+	}{
+		{
+			id:   1,
+			arg:  tm,
+			want: "time.Time(2024-03-10 17:04:05 +0000 UTC)",
+		},
+		{
+			id:   2,
+			arg:  tm.In(pst).Add(time.Millisecond),
+			want: "time.Time(2024-03-10 09:04:05.001 -0800 PST)",
+		},
+		{
+			id:    3,
+			arg:   tm,
+			zones: []*time.Location{time.UTC, pst},
+			want:  "time.Time(2024-03-10 17:04:05 +0000 UTC / 2024-03-10 09:04:05 -0800 PST)",
+		},
+		{
+			id:    4,
+			arg:   struct{ At time.Time }{tm},
+			zones: []*time.Location{pst},
+			want:  "struct { At time.Time }{\n    At: time.Time(2024-03-10 09:04:05 -0800 PST),\n}",
+		},
+	}
+
+	for _, tc := range testCases {
+		if got := formatValue(tc.arg, formatOptions{timeZones: tc.zones}); got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:  %s\nwant: %s", tc.id, got, tc.want)
+		}
+	}
+}
+
+// TestFormatContext verifies that formatValue() prints contexts as their
+// deadline and state, rather than the chain of parent contexts.
+func TestFormatContext(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	past := time.Date(2024, 3, 10, 17, 4, 5, 0, time.UTC)
+	expired, cancel := context.WithDeadline(context.WithValue(context.Background(), point{}, 1), past)
+	defer cancel()
+
+	future, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+
+	testCases := []struct {
+		id     int
+		arg    interface{}
+		prefix string
+		suffix string
+	}{
+		{
+			id:     1,
+			arg:    context.Background(),
+			prefix: "context.backgroundCtx{deadline: none, done: false}",
+		},
+		{
+			id:     2,
+			arg:    canceled,
+			prefix: "*context.cancelCtx{deadline: none, done: true, err: context canceled}",
+		},
+		{
+			id:     3,
+			arg:    expired,
+			prefix: "*context.timerCtx{deadline: 2024-03-10 17:04:05 +0000 UTC (",
+			suffix: " ago), done: true, err: context deadline exceeded}",
+		},
+		{
+			id:     4,
+			arg:    future,
+			prefix: "*context.timerCtx{deadline: ",
+			suffix: " left), done: false}",
+		},
+		{
+			id: 5,
+			arg: struct {
+				ID  int
+				Ctx context.Context
+			}{ID: 1},
+			prefix: "struct { ID int; Ctx context.Context }{\n    ID:  1,\n    Ctx: nil,\n}",
+		},
+	}
+
+	for _, tc := range testCases {
+		got := formatValue(tc.arg, formatOptions{})
+		if !strings.HasPrefix(got, tc.prefix) || !strings.HasSuffix(got, tc.suffix) {
+			t.Fatalf("\nTEST %d\ngot:  %s\nwant: %s...%s", tc.id, got, tc.prefix, tc.suffix)
+		}
+	}
+
+	// The time left is measured with the clock in the options.
+	for i, now := range []time.Time{past.Add(-90 * time.Second), past.Add(2 * time.Hour)} {
+		opts := formatOptions{now: func() time.Time { return now }}
+		got := formatValue(expired, opts)
+		want := []string{" (1m30s left)", " (2h0m0s ago)"}[i]
+		if !strings.Contains(got, want) {
+			t.Fatalf("\nTEST %d\ngot:  %s\nwant: ...%s...", i+6, got, want)
+		}
+	}
+}
+
+// fileInfo is an fs.FileInfo with fixed values.
+type fileInfo struct {
+	name string
+	size int64
+	mode fs.FileMode
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi fileInfo) ModTime() time.Time { return time.Date(2024, 3, 10, 17, 4, 5, 0, time.UTC) }
+func (fi fileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi fileInfo) Sys() interface{}   { return nil }
+
+// TestFormatFileInfo verifies that formatValue() prints fs.FileInfos as their
+// name, size, mode, modification time, and whether they're directories.
+func TestFormatFileInfo(t *testing.T) {
+	testCases := []struct {
+		id   int
+		arg  interface{}
+		want string
+	}{
+		{
+			id:   1,
+			arg:  fileInfo{"q.go", 12601, 0644},
+			want: `q.fileInfo{name: "q.go", size: 12.3 KiB, mode: -rw-r--r--, modTime: 2024-03-10 17:04:05 +0000 UTC, dir: false}`,
+		},
+		{
+			id:   2,
+			arg:  &fileInfo{"testdata", 96, fs.ModeDir | 0755},
+			want: `*q.fileInfo{name: "testdata", size: 96 B, mode: drwxr-xr-x, modTime: 2024-03-10 17:04:05 +0000 UTC, dir: true}`,
+		},
+		{
+			id:   3,
+			arg:  (*fileInfo)(nil),
+			want: "(*q.fileInfo)(nil)",
+		},
+		{
+			id:   4,
+			arg:  []fs.FileInfo{nil},
+			want: "[]fs.FileInfo{\n    nil,\n}",
+		},
+	}
+
+	for _, tc := range testCases {
+		if got := formatValue(tc.arg, formatOptions{}); got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:  %s\nwant: %s", tc.id, got, tc.want)
+		}
+	}
+}
+
+// TestFormatFormatter verifies that formatValue() prints fmt.Formatters with
+// their own Format method, using the configured verb.
+func TestFormatFormatter(t *testing.T) {
+	testCases := []struct {
+		id   int
+		arg  interface{}
+		verb string
+		want string
+	}{
+		{
+			id:   1,
+			arg:  money{1250, "EUR"},
+			want: "q.money(12.50 EUR)",
+		},
+		{
+			id:   2,
+			arg:  money{1250, "EUR"},
+			verb: "%v",
+			want: "q.money(12.50)",
+		},
+		{
+			id:   3,
+			arg:  &money{99, "USD"},
+			want: "*q.money(0.99 USD)",
+		},
+		{
+			id:   4,
+			arg:  []money{{100, "USD"}},
+			verb: "%#v",
+			want: "[]q.money{\n    money{100, \"USD\"},\n}",
+		},
+		{
+			id:   5,
+			arg:  (*money)(nil),
+			want: "(*q.money)(nil)",
+		},
+	}
+
+	for _, tc := range testCases {
+		if got := formatValue(tc.arg, formatOptions{formatterVerb: tc.verb}); got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:  %s\nwant: %s", tc.id, got, tc.want)
+		}
+	}
+}
+
+// money is a fmt.Formatter, for testing that q uses its Format method.
+type money struct {
+	cents    int
+	currency string
+}
+
+func (m money) Format(f fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && f.Flag('#'):
+		fmt.Fprintf(f, "money{%d, %q}", m.cents, m.currency)
+	case verb == 'v' && f.Flag('+'):
+		fmt.Fprintf(f, "%d.%02d %s", m.cents/100, m.cents%100, m.currency)
+	default:
+		fmt.Fprintf(f, "%d.%02d", m.cents/100, m.cents%100)
+	}
+}
+
+// TestFormatPanic verifies that formatValue() prints a panic in one of the
+// value's methods instead of panicking.
+func TestFormatPanic(t *testing.T) {
+	testCases := []struct {
+		id   int
+		arg  interface{}
+		want string
+	}{
+		{
+			id:   1,
+			arg:  []error{badError{}},
+			want: "<panic formatting []error: bad Error() method>",
+		},
+		{
+			id:   2,
+			arg:  badError{},
+			want: "q.badError{}",
+		},
+	}
+
+	for _, tc := range testCases {
+		if got := formatValue(tc.arg, formatOptions{}); got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:  %s\nwant: %s", tc.id, got, tc.want)
+		}
+	}
+}
+
+// badError is an error whose Error method panics.
+type badError struct{}
+
+func (badError) Error() string { panic("bad Error() method") }
+
+// TestFormatHexDump verifies that formatValue() prints byte slices as hexdumps
+// with the configured width when hexDump is set.
+func TestFormatHexDump(t *testing.T) {
+	hello := []byte("hello world\n")
+
+	testCases := []struct {
+		id    int
+		arg   interface{}
+		width int
+		want  string
+	}{
+		{
+			id:  1,
+			arg: hello,
+			want: "[]uint8{\n" +
+				"    00000000  68 65 6c 6c 6f 20 77 6f  72 6c 64 0a              |hello world.|\n" +
+				"}",
+		},
+		{
+			id:    2,
+			arg:   hello,
+			width: 8,
+			want: "[]uint8{\n" +
+				"    00000000  68 65 6c 6c 6f 20 77 6f  |hello wo|\n" +
+				"    00000008  72 6c 64 0a              |rld.|\n" +
+				"}",
+		},
+		{
+			id:    3,
+			arg:   append(hello, hello...),
+			width: 32,
+			want: "[]uint8{\n" +
+				"    00000000  68 65 6c 6c 6f 20 77 6f  72 6c 64 0a 68 65 6c 6c  " +
+				"6f 20 77 6f 72 6c 64 0a                           |hello world.hello world.|\n" +
+				"}",
+		},
+		{
+			id:   4,
+			arg:  []byte{},
+			want: "[]uint8{}",
+		},
+		{
+			id:   5,
+			arg:  []byte(nil),
+			want: "[]uint8(nil)",
+		},
+	}
+
+	for _, tc := range testCases {
+		got := formatValue(tc.arg, formatOptions{hexDump: true, hexWidth: tc.width})
+		if got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:  %s\nwant: %s", tc.id, got, tc.want)
+		}
+	}
+}
+
+// TestFormatUTF8Bytes verifies that formatValue() prints byte slices that are
+// valid UTF-8 as strings, and the others as hexdumps.
+func TestFormatUTF8Bytes(t *testing.T) {
+	testCases := []struct {
+		id   int
+		arg  interface{}
+		opts []Option
+		want string
+	}{
+		{
+			id:   1,
+			arg:  []byte(`{"ok":true}`),
+			want: `[]uint8("{\"ok\":true}")`,
+		},
+		{
+			id:   2,
+			arg:  []byte("héllo\n"),
+			want: `[]uint8("héllo\n")`,
+		},
+		{
+			id:  3,
+			arg: []byte{0xff, 0xfe, 'h', 'i'},
+			want: "[]uint8{\n" +
+				"    00000000  ff fe 68 69                                       |..hi|\n" +
+				"}",
+		},
+		{
+			id:   4,
+			arg:  struct{ Body []byte }{[]byte("hi")},
+			want: "struct { Body []uint8 }{\n    Body: \"hi\",\n}",
+		},
+		{
+			id:   5,
+			arg:  []byte("hi"),
+			opts: []Option{WithBytesHex()},
+			want: "[]uint8{\n" +
+				"    00000000  68 69                                             |hi|\n" +
+				"}",
+		},
+		{
+			id:   6,
+			arg:  []byte(nil),
+			want: "[]uint8(nil)",
+		},
+	}
+
+	for _, tc := range testCases {
+		got := formatValue(tc.arg, callOptions(formatOptions{utf8Bytes: true}, tc.opts))
+		if got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:  %s\nwant: %s", tc.id, got, tc.want)
+		}
+	}
+}
+
+// TestFormatComplex verifies that formatValue() prints complex numbers in the
+// configured form and precision.
+func TestFormatComplex(t *testing.T) {
+	testCases := []struct {
+		id   int
+		arg  interface{}
+		form ComplexFormat
+		prec int
+		want string
+	}{
+		{1, 1 + 2i, ComplexRectangular, -1, "(1+2i)"},
+		{2, 1 + 2i, ComplexRectangular, 2, "(1.00+2.00i)"},
+		{3, complex64(0.1 + 0.2i), ComplexRectangular, -1, "(0.10000000149011612+0.20000000298023224i)"},
+		{4, complex64(0.1 + 0.2i), ComplexRectangular, 1, "(0.1+0.2i)"},
+		{5, 1 + 2i, ComplexPolar, -1, "(2.23606797749979∠1.1071487177940904)"},
+		{6, 1 + 2i, ComplexPolar, 2, "(2.24∠1.11)"},
+		{7, 0i, ComplexPolar, -1, "(0∠0)"},
+		{8, 2i, ComplexPolar, 3, "(2.000∠1.571)"},
+		{9, 0i, ComplexRectangular, 1, "(0.0+0.0i)"},
+		{10, []complex128{-1}, ComplexPolar, 2, "[]complex128{(1.00∠3.14)}"},
+	}
+
+	for _, tc := range testCases {
+		opts := formatOptions{complexForm: tc.form, complexPrec: tc.prec + 1}
+		if got := formatValue(tc.arg, opts); got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:  %s\nwant: %s", tc.id, got, tc.want)
+		}
+	}
+}
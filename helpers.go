@@ -12,13 +12,23 @@ import (
 	"go/parser"
 	"go/printer"
 	"go/token"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"reflect"
+	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"unicode/utf8"
-
-	"github.com/kr/pretty"
 )
 
+// predeclared are the predeclared identifiers that are values, which argName()
+// treats as literals.
+var predeclared = map[string]bool{"true": true, "false": true, "nil": true, "iota": true}
+
 // argName returns the source text of the given argument if it's a variable or
 // an expression. If the argument is something else, like a literal, argName
 // returns an empty string.
@@ -26,7 +36,14 @@ func argName(arg ast.Expr) string {
 	name := ""
 	switch a := arg.(type) {
 	case *ast.Ident:
-		if a.Obj.Kind == ast.Var || a.Obj.Kind == ast.Con {
+		switch {
+		case a.Obj == nil:
+			// Not declared in this file: either predeclared, like true and
+			// nil, which are literals, or declared in another file.
+			if !predeclared[a.Name] {
+				name = a.Name
+			}
+		case a.Obj.Kind == ast.Var || a.Obj.Kind == ast.Con:
 			name = a.Obj.Name
 		}
 	case *ast.BinaryExpr,
@@ -36,6 +53,7 @@ func argName(arg ast.Expr) string {
 		*ast.ParenExpr,
 		*ast.SelectorExpr,
 		*ast.SliceExpr,
+		*ast.StarExpr,
 		*ast.TypeAssertExpr,
 		*ast.UnaryExpr:
 		name = exprToString(arg)
@@ -43,14 +61,33 @@ func argName(arg ast.Expr) string {
 	return name
 }
 
+// argNames returns the names of the arguments of the q call at the given file
+// and line, from the resolver set with SetNameResolver(), or if there isn't
+// one, or it fails, from the source.
+func (l *logger) argNames(filename string, line int) ([]string, error) {
+	if l.nameResolver != nil {
+		if names, err := l.nameResolver(filename, line); err == nil {
+			return names, nil
+		}
+	}
+	return argNames(filename, line)
+}
+
 // argNames finds the q.Q() call at the given filename/line number and
 // returns its arguments as a slice of strings. If the argument is a literal,
 // argNames will return an empty string at the index position of that argument.
 // For example, q.Q(ip, port, 5432) would return []string{"ip", "port", ""}.
-// argNames returns an error if the source text cannot be parsed.
+// Other arguments are returned as they're written in the source, e.g.
+// "len(items)" or "user.Address.City". argNames returns an error if the source
+// text cannot be parsed.
 func argNames(filename string, line int) ([]string, error) {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %q: %v", filename, err)
+	}
+
 	fset := token.NewFileSet()
-	f, err := parser.ParseFile(fset, filename, nil, 0)
+	f, err := parser.ParseFile(fset, filename, src, 0)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse %q: %v", filename, err)
 	}
@@ -75,7 +112,11 @@ func argNames(filename string, line int) ([]string, error) {
 		}
 
 		for _, arg := range call.Args {
-			names = append(names, argName(arg))
+			name := argName(arg)
+			if name != "" {
+				name = sourceText(fset, src, arg)
+			}
+			names = append(names, name)
 		}
 		return true
 	})
@@ -83,6 +124,33 @@ func argNames(filename string, line int) ([]string, error) {
 	return names, nil
 }
 
+// disambiguate returns names with an index appended to the names that appear
+// more than once, e.g. q.Q(x, x) gives "x#1" and "x#2", so each value can be
+// told apart. Empty names, i.e. literals, are left as they are.
+func disambiguate(names []string) []string {
+	count := make(map[string]int, len(names))
+	for _, name := range names {
+		count[name]++
+	}
+
+	var out []string
+	seen := make(map[string]int)
+	for i, name := range names {
+		if name == "" || count[name] < 2 {
+			continue
+		}
+		if out == nil {
+			out = append([]string(nil), names...)
+		}
+		seen[name]++
+		out[i] = name + "#" + strconv.Itoa(seen[name])
+	}
+	if out == nil {
+		return names
+	}
+	return out
+}
+
 // argWidth returns the number of characters that will be seen when the given
 // argument is printed at the terminal.
 func argWidth(arg string) int {
@@ -93,18 +161,23 @@ func argWidth(arg string) int {
 		"\r", "",
 		"\f", "",
 		"\v", "",
-		string(bold), "",
-		string(yellow), "",
-		string(cyan), "",
-		string(endColor), "",
 	)
-	s := replacer.Replace(arg)
+	s := replacer.Replace(stripColor(arg))
 	return utf8.RuneCountInString(s)
 }
 
+// colorCode matches ANSI color escape codes, including ones from color rules
+// that q doesn't define itself.
+var colorCode = regexp.MustCompile("\033\\[[0-9;]*m")
+
+// stripColor returns the given text without ANSI color escape codes.
+func stripColor(text string) string {
+	return colorCode.ReplaceAllString(text, "")
+}
+
 // colorize returns the given text encapsulated in ANSI escape codes that
 // give the text color in the terminal.
-func colorize(text string, c color) string {
+func colorize(text string, c Color) string {
 	return string(c) + text + string(endColor)
 }
 
@@ -119,27 +192,262 @@ func exprToString(arg ast.Expr) string {
 	return strings.Replace(buf.String(), "\t", "    ", -1)
 }
 
+// sourceText returns the source text of the given expression as it's written
+// in src, trimmed, with tabs replaced by spaces, like exprToString(). If the
+// expression's position isn't in src, it falls back to exprToString().
+func sourceText(fset *token.FileSet, src []byte, x ast.Expr) string {
+	start, end := fset.Position(x.Pos()).Offset, fset.Position(x.End()).Offset
+	if start < 0 || start > end || end > len(src) {
+		return exprToString(x)
+	}
+	text := strings.TrimSpace(string(src[start:end]))
+	return strings.Replace(text, "\t", "    ", -1)
+}
+
 // formatArgs converts the given args to pretty-printed, colorized strings.
-func formatArgs(args ...interface{}) []string {
+func formatArgs(opts formatOptions, args ...interface{}) []string {
 	formatted := make([]string, 0, len(args))
 	for _, a := range args {
-		s := colorize(pretty.Sprint(a), cyan)
+		opts.baseColor = valueColor(opts, a)
+		s := colorize(formatValue(a, opts), opts.baseColor)
 		formatted = append(formatted, s)
 	}
 	return formatted
 }
 
+// builtinColorRules are the color rules that are checked after the ones added
+// with AddColorRule(), so those can override them. Unlike those, they're given
+// the format options, so they only look at what's printed.
+var builtinColorRules = []func(opts formatOptions, v interface{}) (Color, bool){anomalyRule}
+
+// valueColor returns the color of the first color rule that matches v, or cyan
+// if none do. See AddColorRule().
+func valueColor(opts formatOptions, v interface{}) Color {
+	for _, rule := range opts.colorRules {
+		if c, ok := rule(v); ok {
+			return c
+		}
+	}
+	for _, rule := range builtinColorRules {
+		if c, ok := rule(opts, v); ok {
+			return c
+		}
+	}
+	return cyan
+}
+
+// anomalyRule colors values that are or hold a NaN or ±Inf float red.
+func anomalyRule(opts formatOptions, v interface{}) (Color, bool) {
+	p := &valuePrinter{opts: opts}
+	return Red, p.hasAnomaly(reflect.ValueOf(v), make(map[visit]bool))
+}
+
+// hasAnomaly returns true if v is a NaN or ±Inf float, or holds one that would
+// be printed: it stops where the printer would exceed the max depth, and looks
+// into each pointer, map, and slice once. See WithMaxDepth().
+func (p *valuePrinter) hasAnomaly(v reflect.Value, seen map[visit]bool) bool {
+	if !v.IsValid() || p.depthExceeded(p.depth) {
+		return false
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		if v.IsNil() {
+			return false
+		}
+		vis := visit{v.Pointer(), v.Type()}
+		if seen[vis] {
+			return false
+		}
+		seen[vis] = true
+	}
+
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return isAnomaly(v.Float())
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return false
+		}
+		pp := *p
+		pp.depth++
+		return pp.hasAnomaly(v.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if p.hasAnomaly(v.Field(i), seen) {
+				return true
+			}
+		}
+	case reflect.Array, reflect.Slice:
+		switch v.Type().Elem().Kind() {
+		case reflect.Bool, reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+			reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return false // e.g. a []byte, which may be long
+		}
+		for i := 0; i < v.Len(); i++ {
+			if p.hasAnomaly(v.Index(i), seen) {
+				return true
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if p.hasAnomaly(iter.Key(), seen) || p.hasAnomaly(iter.Value(), seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // getCallerInfo returns the name, file, and line number of the function calling
-// q.Q().
+// q.Q(). Functions marked with MarkHelper() are skipped.
 func getCallerInfo() (funcName, file string, line int, err error) {
-	const callDepth = 2 // user code calls q.Q() which calls std.log().
-	pc, file, line, ok := runtime.Caller(callDepth)
-	if !ok {
-		return "", "", 0, errors.New("failed to get info about the function calling q.Q")
+	const callDepth = 2 // user code calls q.Q() which calls getCallerInfo().
+	for depth := callDepth; ; depth++ {
+		pc, file, line, ok := runtime.Caller(depth)
+		if !ok {
+			return "", "", 0, errors.New("failed to get info about the function calling q.Q")
+		}
+
+		funcName = runtime.FuncForPC(pc).Name()
+		if !isHelper(funcName) {
+			return funcName, file, line, nil
+		}
 	}
+}
 
-	funcName = runtime.FuncForPC(pc).Name()
-	return funcName, file, line, nil
+// getTraceInfo is like getCallerInfo, but for q.QTrace(). It also returns the
+// file and line of the call to the function calling q.QTrace(). If they're
+// unknown, callerFile is empty.
+func getTraceInfo() (funcName, file string, line int, callerFile string, callerLine int, err error) {
+	const callDepth = 2 // user code calls q.QTrace() which calls getTraceInfo().
+	for depth := callDepth; ; depth++ {
+		pc, file, line, ok := runtime.Caller(depth)
+		if !ok {
+			return "", "", 0, "", 0, errors.New("failed to get info about the function calling q.QTrace")
+		}
+
+		funcName = runtime.FuncForPC(pc).Name()
+		if isHelper(funcName) {
+			continue
+		}
+		_, callerFile, callerLine, _ = runtime.Caller(depth + 1)
+		return funcName, file, line, callerFile, callerLine, nil
+	}
+}
+
+// callText returns the source text of the call to the function with the given
+// name at filename/line number, e.g. h.handle(req, user). The call may span
+// several lines, as long as the given line is one of them. If there's no call
+// to the function there, e.g. because it's a closure, it returns the first call
+// that starts or ends on the line.
+func callText(filename string, line int, funcName string) (string, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, nil, 0)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %q: %v", filename, err)
+	}
+
+	name := shortFuncName(funcName)
+	var first, match *ast.CallExpr
+	ast.Inspect(f, func(n ast.Node) bool {
+		call, is := n.(*ast.CallExpr)
+		if !is {
+			return match == nil
+		}
+
+		start, end := fset.Position(call.Pos()).Line, fset.Position(call.End()).Line
+		if first == nil && (start == line || end == line) {
+			first = call
+		}
+		if match == nil && start <= line && line <= end && calledName(call) == name {
+			match = call
+		}
+		return match == nil
+	})
+
+	switch {
+	case match != nil:
+		return exprToString(match), nil
+	case first != nil:
+		return exprToString(first), nil
+	}
+	return "", fmt.Errorf("no function call at %s:%d", filename, line)
+}
+
+// shortFuncName returns the name of a function, as returned by
+// runtime.FuncForPC(), without its package and receiver, e.g.
+// github.com/org/repo.(*Server).handle -> handle.
+func shortFuncName(funcName string) string {
+	if i := strings.Index(funcName, "["); i >= 0 {
+		funcName = funcName[:i] // generic type parameters: F[...]
+	}
+	return funcName[strings.LastIndex(funcName, ".")+1:]
+}
+
+// calledName returns the name of the function called by call, e.g. handle for
+// h.handle(req). It returns an empty string if call calls an expression, like a
+// function literal.
+func calledName(call *ast.CallExpr) string {
+	switch fun := call.Fun.(type) {
+	case *ast.Ident:
+		return fun.Name
+	case *ast.SelectorExpr:
+		return fun.Sel.Name
+	}
+	return ""
+}
+
+var (
+	helperMu sync.Mutex                   // protects helpers
+	helpers  = map[int64]map[string]int{} // goroutine ID -> function name -> times it's marked by MarkHelper()
+)
+
+// isHelper returns true if the function with the given name was marked by
+// MarkHelper() on the current goroutine.
+func isHelper(funcName string) bool {
+	helperMu.Lock()
+	defer helperMu.Unlock()
+
+	if len(helpers) == 0 {
+		return false // don't bother getting the goroutine ID
+	}
+	return helpers[goroutineID()][funcName] > 0
+}
+
+var (
+	spawnMu sync.Mutex           // protects spawns
+	spawns  = map[int64]string{} // goroutine ID -> where it was started by q.Go()
+)
+
+// spawnSite returns where the current goroutine was started by q.Go(), e.g.
+// "main.go:80 main.main", or "" if it wasn't started by q.Go().
+func spawnSite() string {
+	spawnMu.Lock()
+	defer spawnMu.Unlock()
+
+	if len(spawns) == 0 {
+		return "" // don't bother getting the goroutine ID
+	}
+	return spawns[goroutineID()]
+}
+
+// goroutineID returns the ID of the current goroutine, by parsing the first
+// line of its stack trace, e.g. "goroutine 18 [running]:". It returns 0 if the
+// ID can't be parsed.
+func goroutineID() int64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+	id, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
 }
 
 // prependArgName turns argument names and values into name=value strings, e.g.
@@ -147,34 +455,191 @@ func getCallerInfo() (funcName, file string, line int, err error) {
 // color codes. If no name is given, just the value will be returned.
 func prependArgName(names, values []string) []string {
 	prepended := make([]string, len(values))
-	for i, name := range names {
-		if name == "" {
-			prepended[i] = values[i]
+	for i, value := range values {
+		if i >= len(names) || names[i] == "" {
+			prepended[i] = value
 			continue
 		}
-		name = colorize(name, bold)
-		prepended[i] = fmt.Sprintf("%s=%s", name, values[i])
+		name := colorize(names[i], bold)
+		prepended[i] = fmt.Sprintf("%s=%s", name, value)
 	}
 	return prepended
 }
 
-// isQCall returns true if the given function call expression is Q() or q.Q().
+// noSuchField is printed by QFields() in place of fields that don't exist.
+const noSuchField = "<no such field>"
+
+// nilEmbedded is printed by QFields() in place of fields promoted from a nil
+// embedded pointer of an unexported type, which can't be printed itself.
+const nilEmbedded = "<nil>"
+
+// fieldValue returns the value of the exported field at the given dotted path,
+// e.g. "Addr.City", in the struct v. Pointers and interfaces along the path,
+// including embedded ones, are followed. If one of them is nil, it's returned
+// instead of the field, or nilEmbedded if it's unexported. If the field
+// doesn't exist, or isn't exported, noSuchField is returned.
+func fieldValue(v reflect.Value, path string) interface{} {
+	for _, name := range strings.Split(path, ".") {
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return v.Interface()
+			}
+			v = v.Elem()
+		}
+
+		if v.Kind() != reflect.Struct {
+			return noSuchField
+		}
+		f, ok := v.Type().FieldByName(name)
+		if !ok || f.PkgPath != "" {
+			return noSuchField
+		}
+		// A promoted field is reached through embedded structs, which may
+		// be nil pointers.
+		for i, x := range f.Index {
+			if i > 0 && v.Kind() == reflect.Ptr {
+				if v.IsNil() {
+					if v.CanInterface() {
+						return v.Interface()
+					}
+					return nilEmbedded
+				}
+				v = v.Elem()
+			}
+			v = v.Field(x)
+		}
+	}
+
+	if !v.CanInterface() {
+		return noSuchField
+	}
+	return v.Interface()
+}
+
+// fieldNames returns the names QFields() prints for the given field paths. If
+// the struct passed to QFields() has a name, e.g. user, it's prepended to each
+// path, e.g. user.Addr.City.
+func fieldNames(argNames, fields []string) []string {
+	base := ""
+	if len(argNames) > 0 {
+		base = argNames[0]
+	}
+
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		if base != "" {
+			f = base + "." + f
+		}
+		names[i] = f
+	}
+	return names
+}
+
+// missingValue is printed by QReturn() for a name that has no value after it.
+const missingValue = "<missing value>"
+
+// returnValues splits the name, pointer pairs given to QReturn() into names and
+// values. The pointers are dereferenced. Anything that isn't a pointer is
+// logged as is.
+func returnValues(pairs []interface{}) (names []string, values []interface{}) {
+	for i := 0; i < len(pairs); i += 2 {
+		names = append(names, fmt.Sprint(pairs[i]))
+		if i+1 == len(pairs) {
+			values = append(values, missingValue)
+			break
+		}
+
+		v := reflect.ValueOf(pairs[i+1])
+		if v.Kind() != reflect.Ptr || v.IsNil() || !v.Elem().CanInterface() {
+			values = append(values, pairs[i+1])
+			continue
+		}
+		values = append(values, v.Elem().Interface())
+	}
+	return names, values
+}
+
+// envVars returns the names and values of the variables in env, a list of
+// KEY=value strings like the one returned by os.Environ(), whose names start
+// with prefix. They're sorted by name. Sensitive values are wrapped in secret,
+// so they're redacted when printed.
+func envVars(env []string, prefix string) (names []string, values []interface{}) {
+	vars := make(map[string]string)
+	for _, kv := range env {
+		// On Windows, some variables start with "=", e.g. "=C:=C:\".
+		i := strings.Index(kv, "=")
+		if i <= 0 || !strings.HasPrefix(kv[:i], prefix) {
+			continue
+		}
+		vars[kv[:i]] = kv[i+1:]
+	}
+
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if isSensitiveEnv(name) {
+			values = append(values, secret(vars[name]))
+		} else {
+			values = append(values, vars[name])
+		}
+	}
+	return names, values
+}
+
+// userName returns the name of the current user, for use in a file name. If
+// it's unknown, it returns the user ID.
+func userName() string {
+	u, err := user.Current()
+	if err != nil || u.Username == "" {
+		return strconv.Itoa(os.Getuid())
+	}
+
+	// On Windows, the name includes the domain, e.g. DOMAIN\user.
+	return strings.NewReplacer(`\`, "_", "/", "_").Replace(u.Username)
+}
+
+// qFuncs are the names of the exported functions that log values. When q is
+// dot-imported, these are the calls argNames() looks for.
+var qFuncs = map[string]bool{
+	"Q":          true,
+	"Qn":         true,
+	"QInfo":      true,
+	"QWarn":      true,
+	"QError":     true,
+	"QGroup":     true,
+	"QFields":    true,
+	"Qbuf":       true,
+	"QUnique":    true,
+	"QAt":        true,
+	"QSliceDiff": true,
+	"QOpt":       true,
+	"QCtx":       true,
+	"QInplace":   true,
+	"QSizeof":    true,
+}
+
+// isQCall returns true if the given function call expression is Q() or q.Q(),
+// or one of the other functions in qFuncs.
 func isQCall(n *ast.CallExpr) bool {
 	return isQFunction(n) || isQPackage(n)
 }
 
-// isQFunction returns true if the given function call expression is Q().
+// isQFunction returns true if the given function call expression is Q(), or
+// one of the other functions in qFuncs.
 func isQFunction(n *ast.CallExpr) bool {
 	ident, is := n.Fun.(*ast.Ident)
 	if !is {
 		return false
 	}
-	return ident.Name == "Q"
+	return qFuncs[ident.Name]
 }
 
 // isQPackage returns true if the given function call expression is in the q
-// package. Since Q() is the only exported function from the q package, this is
-// sufficient for determining that we've found Q() in the source text.
+// package. Combined with the line number check in argNames(), this is
+// sufficient for determining that we've found a q call in the source text.
 func isQPackage(n *ast.CallExpr) bool {
 	sel, is := n.Fun.(*ast.SelectorExpr) // SelectorExpr example: a.B()
 	if !is {
@@ -5,11 +5,14 @@
 package q
 
 import (
+	"errors"
 	"fmt"
 	"go/ast"
+	"math"
+	"reflect"
+	"runtime"
+	"strings"
 	"testing"
-
-	"github.com/kr/pretty"
 )
 
 // TestExtractingArgsFromSourceText verifies that exprToString() and argName()
@@ -336,6 +339,21 @@ func TestExtractingArgsFromSourceText(t *testing.T) {
 			},
 			want: "-1",
 		},
+		{
+			id:   16,
+			arg:  &ast.Ident{NamePos: 12, Name: "true"},
+			want: "",
+		},
+		{
+			id:   17,
+			arg:  &ast.Ident{NamePos: 12, Name: "nil"},
+			want: "",
+		},
+		{
+			id:   18,
+			arg:  &ast.Ident{NamePos: 12, Name: "declaredElsewhere"},
+			want: "declaredElsewhere",
+		},
 	}
 
 	// We can test both exprToString() and argName() with the test cases above.
@@ -385,6 +403,76 @@ func TestArgNames(t *testing.T) {
 	}
 }
 
+// TestArgNamesSourceText verifies that argNames() names arguments that are
+// expressions, like function calls, indexing, and method chains, with their
+// source text as written.
+func TestArgNamesSourceText(t *testing.T) {
+	const filename = "testdata/sample4.go"
+	testCases := []struct {
+		line int
+		want []string
+	}{
+		{16, []string{"len(items)", "items[1]", "items[1:]", "u.Address.City", "u.Name().Len()", "*u"}},
+		{22, []string{"", "", "elsewhere", "items"}},
+		{19, []string{"len(items /* all of them */)", "strings.ToUpper(\n        u.Address.City,\n    )", "", ""}},
+	}
+
+	for _, tc := range testCases {
+		got, err := argNames(filename, tc.line)
+		if err != nil {
+			t.Fatalf("argNames: failed to parse %q: %v", filename, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("\nline %d\ngot:  %#v\nwant: %#v", tc.line, got, tc.want)
+		}
+	}
+}
+
+// TestDisambiguate verifies that disambiguate() numbers the names that appear
+// more than once, and leaves the others, and empty names, alone.
+func TestDisambiguate(t *testing.T) {
+	testCases := []struct {
+		id    int
+		names []string
+		want  []string
+	}{
+		{1, []string{"a.X", "b.X"}, []string{"a.X", "b.X"}},
+		{2, []string{"x", "y", "x"}, []string{"x#1", "y", "x#2"}},
+		{3, []string{"", "", "f()", "f()"}, []string{"", "", "f()#1", "f()#2"}},
+		{4, nil, nil},
+	}
+
+	for _, tc := range testCases {
+		orig := append([]string(nil), tc.names...)
+		if got := disambiguate(tc.names); !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("\nTEST %d\ngot:  %q\nwant: %q", tc.id, got, tc.want)
+		}
+		if !reflect.DeepEqual(tc.names, orig) {
+			t.Fatalf("\nTEST %d\nthe names were modified: %q", tc.id, tc.names)
+		}
+	}
+}
+
+// TestDuplicateArgNames verifies that values passed with the same source text
+// are labeled uniquely, that the same field of different values keeps its
+// receiver, and that duplicate literals stay unnamed.
+func TestDuplicateArgNames(t *testing.T) {
+	l := newLogger()
+	l.print(call{
+		funcName: "main.main",
+		file:     "testdata/sample4.go",
+		line:     21,
+		values:   []interface{}{"Oslo", "Rome", "Oslo", 7, 7},
+	})
+
+	got := strings.SplitN(stripColor(l.buf.String()), "\n", 3)[2]
+	want := "0.000s u.Address.City#1=Oslo v.Address.City=Rome u.Address.City#2=Oslo int(7)\n" +
+		"       int(7)\n"
+	if got != want {
+		t.Fatalf("\ngot:  %q\nwant: %q", got, want)
+	}
+}
+
 // TestArgNamesBadFilename verifies that argNames() returns an error if given an
 // invalid filename.
 func TestArgNamesBadFilename(t *testing.T) {
@@ -395,6 +483,39 @@ func TestArgNamesBadFilename(t *testing.T) {
 	}
 }
 
+// TestNameResolver verifies that logger.argNames() uses the names from the
+// resolver set with SetNameResolver(), and falls back to the source when it
+// fails.
+func TestNameResolver(t *testing.T) {
+	l := newLogger()
+	l.nameResolver = func(file string, line int) ([]string, error) {
+		if file == "gen.go" {
+			return []string{"x", ""}, nil
+		}
+		return nil, errors.New("no names")
+	}
+
+	testCases := []struct {
+		id   int
+		file string
+		line int
+		want []string
+	}{
+		{1, "gen.go", 3, []string{"x", ""}},
+		{2, "testdata/sample1.go", 14, []string{"a", "b", "c", "d", "e", "f", "g"}},
+	}
+
+	for _, tc := range testCases {
+		got, err := l.argNames(tc.file, tc.line)
+		if err != nil {
+			t.Fatalf("\nTEST %d\nargNames failed: %v", tc.id, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("\nTEST %d\ngot:  %#v\nwant: %#v", tc.id, got, tc.want)
+		}
+	}
+}
+
 // TestArgWidth verifies that argWidth() returns the correct number of printable
 // characters in a string.
 func TestArgWidth(t *testing.T) {
@@ -408,6 +529,8 @@ func TestArgWidth(t *testing.T) {
 		{colorize("myVar", bold), 5},
 		{colorize("3.14", cyan), 4},
 		{colorize("你好", cyan), 2},
+		{colorize("-5", Red), 2},
+		{colorize("-5", Color("\033[1;91m")), 2},
 	}
 
 	for _, tc := range testCases {
@@ -464,7 +587,7 @@ func TestFormatArgs(t *testing.T) {
 	}
 
 	for _, tc := range testCases {
-		got := formatArgs(tc.args...)
+		got := formatArgs(formatOptions{}, tc.args...)
 
 		if len(got) != len(tc.want) {
 			t.Fatalf("\nTEST %d\ngot:  %s\nwant: %s", tc.id, got, tc.want)
@@ -478,6 +601,90 @@ func TestFormatArgs(t *testing.T) {
 	}
 }
 
+// TestColorRules verifies that formatArgs() colors each value with the first
+// color rule that matches it, or cyan if none do.
+func TestColorRules(t *testing.T) {
+	negative := func(v interface{}) (Color, bool) {
+		n, ok := v.(int)
+		return Red, ok && n < 0
+	}
+	small := func(v interface{}) (Color, bool) {
+		n, ok := v.(int)
+		return Green, ok && n < 10
+	}
+	opts := formatOptions{colorRules: []func(interface{}) (Color, bool){negative, small}}
+
+	got := formatArgs(opts, -1, 5, 50, "x")
+	want := []string{
+		colorize("int(-1)", Red),
+		colorize("int(5)", Green),
+		colorize("int(50)", cyan),
+		colorize("x", cyan),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("\ngot:  %q\nwant: %q", got, want)
+	}
+}
+
+// TestAnomalyColor verifies that formatArgs() prints values that are or hold
+// NaN or ±Inf in red, unless a color rule says otherwise, and counts them.
+func TestAnomalyColor(t *testing.T) {
+	type point struct{ X, Y float64 }
+
+	var n int
+	opts := formatOptions{anomalies: &n}
+
+	got := formatArgs(opts, math.NaN(), []float64{1, math.Inf(-1)}, &point{Y: math.Inf(1)}, 2.5)
+	want := []string{
+		colorize("float64(NaN)", Red),
+		colorize("[]float64{1, -Inf}", Red),
+		colorize("&q.point{X:0, Y:+Inf}", Red),
+		colorize("float64(2.5)", cyan),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("\ngot:  %q\nwant: %q", got, want)
+	}
+	if n != 3 {
+		t.Fatalf("counted %d anomalies, want 3", n)
+	}
+
+	floats := func(v interface{}) (Color, bool) {
+		_, ok := v.(float64)
+		return Yellow, ok
+	}
+	opts.colorRules = []func(interface{}) (Color, bool){floats}
+	got = formatArgs(opts, math.Inf(1), []float64{math.NaN()})
+	want = []string{colorize("float64(+Inf)", Yellow), colorize("[]float64{NaN}", Red)}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("\ngot:  %q\nwant: %q", got, want)
+	}
+	if n != 5 {
+		t.Fatalf("counted %d anomalies, want 5", n)
+	}
+
+	// A NaN too deep to be printed doesn't color the value.
+	nan := math.NaN()
+	pp := &nan
+	opts = formatOptions{maxDepth: 1}
+	got = formatArgs(opts, &pp, pp)
+	want = []string{colorize("&&!%v(DEPTH EXCEEDED)", cyan), colorize("&float64(NaN)", Red)}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("\ngot:  %q\nwant: %q", got, want)
+	}
+
+	// Cycles are only looked into once, even with no depth limit.
+	type node struct {
+		Next *node
+		X    float64
+	}
+	loop := &node{}
+	loop.Next = loop
+	opts = formatOptions{maxDepth: -1}
+	if c := valueColor(opts, loop); c != cyan {
+		t.Fatalf("\ngot:  %q\nwant: %q", c, cyan)
+	}
+}
+
 // TestPrependArgName verifies that prependArgName() correctly merges a slice of
 // variable names and a slice of variabe values into name=value strings.
 func TestPrependArgName(t *testing.T) {
@@ -512,6 +719,14 @@ func TestPrependArgName(t *testing.T) {
 				fmt.Sprintf("%s=%s", colorize("myFunc", bold), colorize("func (n int) bool { return n > 0 }", cyan)),
 			},
 		},
+		{
+			names:  []string{"myVar"},
+			values: []string{colorize("int(1)", cyan), colorize("int(2)", cyan)},
+			want: []string{
+				fmt.Sprintf("%s=%s", colorize("myVar", bold), colorize("int(1)", cyan)),
+				colorize("int(2)", cyan),
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -584,6 +799,13 @@ func TestIsQCall(t *testing.T) {
 			},
 			want: false,
 		},
+		{
+			id: 7,
+			expr: &ast.CallExpr{
+				Fun: &ast.Ident{Name: "Qn"},
+			},
+			want: true,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -592,10 +814,230 @@ func TestIsQCall(t *testing.T) {
 			t.Fatalf(
 				"\nTEST %d\nisQCall(%s)\ngot:  %v\nwant: %v",
 				tc.id,
-				pretty.Sprint(tc.expr),
+				formatValue(tc.expr, formatOptions{}),
 				got,
 				tc.want,
 			)
 		}
 	}
 }
+
+// TestMarkHelper verifies that getCallerInfo() skips functions that have been
+// marked with MarkHelper(), but only on the goroutine that marked them, and
+// that the marks are removed when the helpers return.
+func TestMarkHelper(t *testing.T) {
+	_, _, wantLine, _ := runtime.Caller(0)
+	funcName, _, line, err := helperCaller(true)
+	wantLine++
+	if err != nil {
+		t.Fatalf("getCallerInfo: %v", err)
+	}
+	if !strings.HasSuffix(funcName, ".TestMarkHelper") || line != wantLine {
+		t.Fatalf("\ngot:  %s:%d\nwant: TestMarkHelper:%d", funcName, line, wantLine)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		funcName, _, _, _ := helperCaller(false)
+		if !strings.HasSuffix(funcName, ".helperCaller") {
+			t.Errorf("\ngot:  %s\nwant: helperCaller", funcName)
+		}
+	}()
+	<-done
+
+	helperMu.Lock()
+	defer helperMu.Unlock()
+	if len(helpers) != 0 {
+		t.Fatalf("\ngot:  %v\nwant: no helpers marked", helpers)
+	}
+}
+
+// helperCaller calls q on behalf of its caller. If mark is true, it marks
+// itself as a helper first.
+func helperCaller(mark bool) (funcName, file string, line int, err error) {
+	if mark {
+		defer MarkHelper()()
+	}
+	return fakeQ()
+}
+
+// fakeQ stands in for q.Q(), which calls getCallerInfo() directly.
+func fakeQ() (funcName, file string, line int, err error) {
+	return getCallerInfo()
+}
+
+// TestFieldValue verifies that fieldValue() follows dotted paths through
+// nested structs and pointers, and only returns exported fields.
+func TestFieldValue(t *testing.T) {
+	type address struct {
+		City string
+		zip  string
+	}
+	type user struct {
+		ID   int
+		Addr *address
+		Home address
+	}
+	type inner struct{ X int }
+	type Inner struct{ X int }
+	type outer struct{ *inner }
+	type Outer struct{ *Inner }
+
+	u := user{ID: 5, Addr: &address{City: "Oslo", zip: "0150"}, Home: address{City: "Bergen"}}
+	testCases := []struct {
+		v    interface{}
+		path string
+		want interface{}
+	}{
+		{u, "ID", 5},
+		{u, "Addr.City", "Oslo"},
+		{&u, "Home.City", "Bergen"},
+		{u, "Addr.zip", noSuchField},
+		{u, "Name", noSuchField},
+		{u, "ID.Name", noSuchField},
+		{user{}, "Addr.City", (*address)(nil)},
+		{nil, "ID", noSuchField},
+		{outer{&inner{7}}, "X", 7},
+		{outer{}, "X", nilEmbedded},
+		{Outer{}, "X", (*Inner)(nil)},
+	}
+
+	for _, tc := range testCases {
+		got := fieldValue(reflect.ValueOf(tc.v), tc.path)
+		if got != tc.want {
+			t.Fatalf("\nfieldValue(%#v, %q)\ngot:  %#v\nwant: %#v", tc.v, tc.path, got, tc.want)
+		}
+	}
+}
+
+// TestFieldNames verifies that fieldNames() prepends the name of the struct
+// passed to QFields() to each field path, if it has one.
+func TestFieldNames(t *testing.T) {
+	testCases := []struct {
+		argNames, fields []string
+		want             []string
+	}{
+		{[]string{"user", "", ""}, []string{"ID", "Addr.City"}, []string{"user.ID", "user.Addr.City"}},
+		{[]string{"", ""}, []string{"ID"}, []string{"ID"}},
+		{nil, []string{"ID"}, []string{"ID"}},
+	}
+
+	for _, tc := range testCases {
+		got := fieldNames(tc.argNames, tc.fields)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("\nfieldNames(%q, %q)\ngot:  %q\nwant: %q", tc.argNames, tc.fields, got, tc.want)
+		}
+	}
+}
+
+// TestReturnValues verifies that returnValues() splits the arguments to
+// QReturn() into names and dereferenced values.
+func TestReturnValues(t *testing.T) {
+	n := 5
+	err := errors.New("oops")
+	var nilErr error
+	var nilPtr *int
+
+	testCases := []struct {
+		id         int
+		pairs      []interface{}
+		wantNames  []string
+		wantValues []interface{}
+	}{
+		{1, []interface{}{"n", &n, "err", &err}, []string{"n", "err"}, []interface{}{5, err}},
+		{2, []interface{}{"err", &nilErr}, []string{"err"}, []interface{}{nil}},
+		{3, []interface{}{"p", nilPtr, "v", 7}, []string{"p", "v"}, []interface{}{nilPtr, 7}},
+		{4, []interface{}{"n", &n, "oops"}, []string{"n", "oops"}, []interface{}{5, missingValue}},
+		{5, nil, nil, nil},
+	}
+
+	for _, tc := range testCases {
+		names, values := returnValues(tc.pairs)
+		if !reflect.DeepEqual(names, tc.wantNames) || !reflect.DeepEqual(values, tc.wantValues) {
+			t.Fatalf("\nTEST %d\ngot:  %q %#v\nwant: %q %#v", tc.id, names, values, tc.wantNames, tc.wantValues)
+		}
+	}
+}
+
+// TestEnvVars verifies that envVars() returns the variables with the given
+// prefix sorted by name, and that sensitive values are redacted when printed.
+func TestEnvVars(t *testing.T) {
+	env := []string{
+		"APP_PORT=8080",
+		"HOME=/root",
+		"APP_DB_PASSWORD=hunter2",
+		"APP1=x",
+		"APP_API_Key=abc=",
+		"=C:=C:\\",
+	}
+
+	testCases := []struct {
+		id     int
+		prefix string
+		opts   formatOptions
+		want   []string
+	}{
+		{1, "APP_", formatOptions{}, []string{"APP_API_Key=<redacted>", "APP_DB_PASSWORD=<redacted>", "APP_PORT=8080"}},
+		{2, "APP_", formatOptions{showSecrets: true}, []string{"APP_API_Key=abc=", "APP_DB_PASSWORD=hunter2", "APP_PORT=8080"}},
+		{3, "", formatOptions{}, []string{"APP1=x", "APP_API_Key=<redacted>", "APP_DB_PASSWORD=<redacted>", "APP_PORT=8080", "HOME=/root"}},
+		{4, "NOPE", formatOptions{}, nil},
+	}
+
+	for _, tc := range testCases {
+		names, values := envVars(env, tc.prefix)
+		var got []string
+		for i, name := range names {
+			got = append(got, name+"="+formatValue(values[i], tc.opts))
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("\nTEST %d\ngot:  %q\nwant: %q", tc.id, got, tc.want)
+		}
+	}
+}
+
+// TestCallText verifies that callText() finds the source of the call to the
+// given function.
+func TestCallText(t *testing.T) {
+	testCases := []struct {
+		line     int
+		funcName string
+		want     string
+	}{
+		{9, "github.com/y0ssar1an/q.Qn", `q.Qn("why we're here", a, b)`},
+		{15, "main.main.func1", `q.QGroup("checkpoint", a)`},
+	}
+
+	for _, tc := range testCases {
+		got, err := callText("testdata/sample2.go", tc.line, tc.funcName)
+		if err != nil {
+			t.Fatalf("callText(%d, %q) failed: %v", tc.line, tc.funcName, err)
+		}
+		if got != tc.want {
+			t.Fatalf("\ncallText(%d, %q)\ngot:  %s\nwant: %s", tc.line, tc.funcName, got, tc.want)
+		}
+	}
+
+	if _, err := callText("testdata/sample2.go", 7, "main.main"); err == nil {
+		t.Fatalf("callText() on a line without a call returned no error")
+	}
+}
+
+// TestShortFuncName verifies that shortFuncName() strips the package and
+// receiver from a function name.
+func TestShortFuncName(t *testing.T) {
+	testCases := []struct {
+		funcName, want string
+	}{
+		{"main.main", "main"},
+		{"github.com/org/repo.(*Server).handle", "handle"},
+		{"github.com/org/repo.Map[...]", "Map"},
+		{"main.main.func1", "func1"},
+	}
+
+	for _, tc := range testCases {
+		if got := shortFuncName(tc.funcName); got != tc.want {
+			t.Fatalf("\nshortFuncName(%q)\ngot:  %s\nwant: %s", tc.funcName, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// leakWait is how long the function returned by QLeakCheck() waits for new
+	// goroutines to finish before it reports them.
+	leakWait = 500 * time.Millisecond
+
+	// leakPoll is how often it checks whether they have.
+	leakPoll = 10 * time.Millisecond
+)
+
+// leakIgnored are parts of the stacks of goroutines that QLeakCheck() doesn't
+// report, since they belong to the testing framework, the runtime, or q.
+var leakIgnored = []string{
+	"testing.tRunner(",
+	"testing.(*T).Run(",
+	"testing.runTests(",
+	"testing.(*M).",
+	"os/signal.",
+	"runtime.ensureSigM(",
+	reflect.TypeOf(logger{}).PkgPath() + ".startCapture.",
+}
+
+// QLeakCheck notes which goroutines are running, and returns a function that
+// logs the ones started since then that are still running, with their stacks,
+// e.g. at the end of a test:
+//
+//	func TestServer(t *testing.T) {
+//		defer q.QLeakCheck()()
+//		...
+//	}
+//
+// Note the () at the end: QLeakCheck returns the function to defer. Goroutines
+// often take a moment to exit after they're told to, so the function waits up
+// to 500ms for the new ones to finish before it reports them. It logs a line
+// either way. Goroutines of the testing framework, like parallel subtests,
+// and of the runtime and q itself, aren't reported.
+//
+// The check is best-effort and racy: goroutines that are slow to exit are
+// reported even though they would have finished, and goroutines started by
+// other tests running in parallel are reported as if this one started them.
+func QLeakCheck() func() {
+	if std.quiet.Load() {
+		return func() {}
+	}
+	funcName, file, line, err := getCallerInfo()
+	before := goroutineStacks()
+	return func() {
+		leaked := leakedGoroutines(before, goroutineID())
+		for wait := leakWait; len(leaked) > 0 && wait > 0; wait -= leakPoll {
+			time.Sleep(leakPoll)
+			leaked = leakedGoroutines(before, goroutineID())
+		}
+
+		values := []interface{}{fmt.Sprintf("%d goroutines leaked", len(leaked))}
+		if len(leaked) == 0 {
+			values[0] = "no goroutines leaked"
+		}
+		for _, stack := range leaked {
+			values = append(values, stack)
+		}
+		std.log(call{
+			funcName:  funcName,
+			file:      file,
+			line:      line,
+			callerErr: err,
+			names:     []string{},
+			lines:     true,
+			values:    values,
+		})
+	}
+}
+
+// leakedGoroutines returns the stacks of the goroutines that are running now,
+// but weren't in before, sorted by goroutine ID. The goroutine with ID self,
+// and the ones leakIgnored matches, are left out.
+func leakedGoroutines(before map[int64]string, self int64) []string {
+	now := goroutineStacks()
+	ids := make([]int64, 0, len(now))
+	for id, stack := range now {
+		if _, ok := before[id]; ok || id == self || isIgnoredGoroutine(stack) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	stacks := make([]string, len(ids))
+	for i, id := range ids {
+		stacks[i] = now[id]
+	}
+	return stacks
+}
+
+// isIgnoredGoroutine returns true if the stack of a goroutine matches one of
+// leakIgnored.
+func isIgnoredGoroutine(stack string) bool {
+	for _, s := range leakIgnored {
+		if strings.Contains(stack, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// goroutineStacks returns the stacks of all running goroutines, by goroutine
+// ID. Each starts with a line like "goroutine 18 [chan receive]:".
+func goroutineStacks() map[int64]string {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	stacks := make(map[int64]string)
+	for _, stack := range bytes.Split(bytes.TrimSpace(buf), []byte("\n\n")) {
+		b := bytes.TrimPrefix(stack, []byte("goroutine "))
+		if i := bytes.IndexByte(b, ' '); i >= 0 {
+			b = b[:i]
+		}
+		id, err := strconv.ParseInt(string(b), 10, 64)
+		if err != nil {
+			continue
+		}
+		stacks[id] = string(stack)
+	}
+	return stacks
+}
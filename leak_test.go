@@ -0,0 +1,67 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// leakyWorker blocks until stop is closed.
+func leakyWorker(stop chan struct{}) {
+	<-stop
+}
+
+// TestLeakedGoroutines verifies that leakedGoroutines() reports a goroutine
+// started after the snapshot while it's running, and not once it's done.
+func TestLeakedGoroutines(t *testing.T) {
+	before := goroutineStacks()
+	self := goroutineID()
+	if _, ok := before[self]; !ok {
+		t.Fatalf("goroutine %d is missing from the stacks:\n%v", self, before)
+	}
+
+	stop := make(chan struct{})
+	go leakyWorker(stop)
+
+	var leaked []string
+	for i := 0; i < 100 && (len(leaked) == 0 || !strings.Contains(leaked[0], "[chan receive]")); i++ {
+		time.Sleep(time.Millisecond)
+		leaked = leakedGoroutines(before, self)
+	}
+	if len(leaked) != 1 || !strings.Contains(leaked[0], "q.leakyWorker(") {
+		t.Fatalf("\ngot:  %q\nwant: the stack of leakyWorker", leaked)
+	}
+
+	close(stop)
+	for i := 0; i < 100 && len(leaked) > 0; i++ {
+		time.Sleep(time.Millisecond)
+		leaked = leakedGoroutines(before, self)
+	}
+	if len(leaked) != 0 {
+		t.Fatalf("\ngot:  %q\nwant: no leaked goroutines", leaked)
+	}
+}
+
+// TestIgnoredGoroutine verifies that goroutines of the testing framework
+// aren't reported as leaks.
+func TestIgnoredGoroutine(t *testing.T) {
+	testCases := []struct {
+		id    int
+		stack string
+		want  bool
+	}{
+		{1, "goroutine 7 [chan receive]:\ntesting.tRunner(0xc000102b60, 0x5d2f28)\n", true},
+		{2, "goroutine 9 [select]:\nos/signal.signal_recv()\n", true},
+		{3, "goroutine 12 [chan receive]:\nmain.worker(0xc00001e0c0)\ncreated by main.main in goroutine 1\n", false},
+	}
+
+	for _, tc := range testCases {
+		if got := isIgnoredGoroutine(tc.stack); got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:  %v\nwant: %v", tc.id, got, tc.want)
+		}
+	}
+}
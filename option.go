@@ -0,0 +1,86 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+// Option changes how the values of a single call are printed. See QOpt().
+type Option struct {
+	apply func(*formatOptions)
+}
+
+// QOpt is like Q, but the values are printed with the given options applied
+// on top of the global settings, e.g.
+//
+//	q.QOpt([]q.Option{q.WithMaxDepth(0), q.WithBytesHex()}, resp)
+//
+// Options take precedence over the global settings they overlap with, like
+// SetHexDump(), and later options over earlier ones. They only apply to this
+// call; the global settings are left as they are.
+func QOpt(opts []Option, v ...interface{}) {
+	if std.quiet.Load() {
+		return
+	}
+	funcName, file, line, err := getCallerInfo()
+	std.log(call{
+		funcName:  funcName,
+		file:      file,
+		line:      line,
+		callerErr: err,
+		options:   opts,
+		skip:      1,
+		values:    v,
+	})
+}
+
+// WithMaxDepth prints nested values down to n levels deep, instead of 10.
+// Deeper values are printed as !%v(DEPTH EXCEEDED). 0 means no limit, which is
+// safe, since cycles are still detected.
+func WithMaxDepth(n int) Option {
+	if n == 0 {
+		n = -1
+	}
+	return Option{func(o *formatOptions) { o.maxDepth = n }}
+}
+
+// WithBytesHex prints byte slices as hexdumps, even if they're text and
+// SetBytesAsStringIfUTF8() is on. See SetHexDump().
+func WithBytesHex() Option {
+	return Option{func(o *formatOptions) {
+		o.hexDump = true
+		o.utf8Bytes = false
+	}}
+}
+
+// WithSortedFields prints struct fields sorted by name. See SetSortFields().
+func WithSortedFields() Option {
+	return Option{func(o *formatOptions) { o.sortFields = true }}
+}
+
+// WithCompactStructs prints structs on one line. See SetCompactStructs().
+func WithCompactStructs() Option {
+	return Option{func(o *formatOptions) { o.compact = true }}
+}
+
+// WithPointerAddr prints pointers with their address. See
+// SetShowPointerAddr().
+func WithPointerAddr() Option {
+	return Option{func(o *formatOptions) { o.showAddr = true }}
+}
+
+// WithSecrets prints sensitive values instead of <redacted>. See
+// SetRedaction().
+func WithSecrets() Option {
+	return Option{func(o *formatOptions) { o.showSecrets = true }}
+}
+
+// callOptions returns the format options for a call: the global ones, with the
+// call's options applied on top.
+func callOptions(global formatOptions, opts []Option) formatOptions {
+	for _, o := range opts {
+		if o.apply != nil {
+			o.apply(&global)
+		}
+	}
+	return global
+}
@@ -0,0 +1,100 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"strings"
+	"testing"
+)
+
+type node struct{ Next *node }
+
+// TestCallOptions verifies that per-call options are applied on top of the
+// global settings, without changing them.
+func TestCallOptions(t *testing.T) {
+	var deep *node
+	for i := 0; i < 15; i++ {
+		deep = &node{deep}
+	}
+
+	testCases := []struct {
+		id       int
+		opts     []Option
+		exceeded bool
+	}{
+		{1, nil, true},
+		{2, []Option{WithMaxDepth(0)}, false},
+		{3, []Option{WithMaxDepth(20)}, false},
+		{4, []Option{WithMaxDepth(0), WithMaxDepth(3)}, true},
+	}
+
+	for _, tc := range testCases {
+		got := formatValue(deep, callOptions(formatOptions{}, tc.opts))
+		if exceeded := strings.Contains(got, "DEPTH EXCEEDED"); exceeded != tc.exceeded {
+			t.Fatalf("\nTEST %d\ngot:  %s\nwant depth exceeded: %t", tc.id, got, tc.exceeded)
+		}
+	}
+
+	global := formatOptions{sortFields: true}
+	opts := callOptions(global, []Option{WithBytesHex(), WithCompactStructs()})
+	if !opts.hexDump || !opts.compact || !opts.sortFields {
+		t.Fatalf("call options %+v don't include both the global and call settings", opts)
+	}
+	if global.hexDump || global.compact {
+		t.Fatalf("call options changed the global settings: %+v", global)
+	}
+}
+
+// TestMaxDepthCycles verifies that values that contain themselves through a
+// map, slice, or pointer are printed once, without a depth limit, and that the
+// same value printed twice side by side isn't taken for a cycle.
+func TestMaxDepthCycles(t *testing.T) {
+	m := map[string]interface{}{}
+	m["self"] = m
+	s := []interface{}{nil}
+	s[0] = s
+	var x interface{}
+	x = &x
+	shared := map[string]int{"a": 1}
+
+	testCases := []struct {
+		id   int
+		arg  interface{}
+		want string
+	}{
+		{1, m, "map[string]interface {}{\n    \"self\": map[string]interface {}{(CYCLIC REFERENCE)},\n}"},
+		{2, s, "[]interface {}{\n    []interface {}{(CYCLIC REFERENCE)},\n}"},
+		{3, &x, "&*interface {}(CYCLIC REFERENCE)"},
+		{4, []map[string]int{shared, shared}, "[]map[string]int{\n    {\"a\":1},\n    {\"a\":1},\n}"},
+	}
+
+	opts := callOptions(formatOptions{}, []Option{WithMaxDepth(0)})
+	for _, tc := range testCases {
+		if got := formatValue(tc.arg, opts); got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:  %s\nwant: %s", tc.id, got, tc.want)
+		}
+	}
+}
+
+// TestQOpt verifies that a call's options only apply to that call.
+func TestQOpt(t *testing.T) {
+	l := newLogger()
+	c := call{
+		funcName: "main.main",
+		file:     "testdata/sample1.go",
+		line:     14,
+		names:    []string{"b"},
+		values:   []interface{}{[]byte("hi")},
+		options:  []Option{WithBytesHex()},
+	}
+	l.print(c)
+	c.options = nil
+	l.print(c)
+
+	got := stripColor(l.buf.String())
+	if !strings.Contains(got, "00000000  68 69") || !strings.Contains(got, "b=[]uint8{0x68, 0x69}") {
+		t.Fatalf("\ngot:  %s\nwant: a hexdump, then a plain byte slice", got)
+	}
+}
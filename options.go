@@ -0,0 +1,657 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"os"
+	"time"
+)
+
+// Format is a layout for q's output. See SetFormat().
+type Format int
+
+const (
+	// FormatText is the default format, with ANSI colors.
+	FormatText Format = iota
+
+	// FormatPlain is meant for reading the log file with a pager, or
+	// searching it with grep. It has no ANSI color codes at all, so there's no
+	// need for less -R. Since the group headers can't stand out by color, they
+	// are set off with an ASCII rule instead, e.g.
+	//
+	//	-- [14:00:36 main.go:122 main.main] ----------------------------
+	FormatPlain
+
+	// FormatJSON writes each call as one line of JSON, with the fields of its
+	// Record, for programs to read. It can only be used for sinks. See
+	// AddSink().
+	FormatJSON
+)
+
+// ComplexFormat is a way of printing complex numbers. See SetComplexFormat().
+type ComplexFormat int
+
+const (
+	// ComplexRectangular prints complex numbers as real and imaginary parts,
+	// e.g. (1+2i), like fmt does.
+	ComplexRectangular ComplexFormat = iota
+
+	// ComplexPolar prints complex numbers as magnitude and angle in radians,
+	// e.g. (2.23606797749979∠1.1071487177940904).
+	ComplexPolar
+)
+
+// SetFormat sets the format of the log file. The default is FormatText.
+// FormatJSON is only for sinks, so SetFormat(FormatJSON) does nothing.
+func SetFormat(f Format) {
+	if f == FormatJSON {
+		return
+	}
+
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.format = f
+}
+
+// SetHeaderRefreshInterval makes q reprint the header line at least every d,
+// even if the same function keeps logging without a 2s pause. This keeps the
+// file and function name in view while scrolling through a long log group.
+// 0, the default, disables it.
+func SetHeaderRefreshInterval(d time.Duration) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.headerRefresh = d
+}
+
+// SetVerbose turns logging on or off. When it's off, Q() and friends return
+// without formatting anything or touching the log file. It's on by default.
+// Wire it to your own flag to control debug output from one place:
+//
+//	q.SetVerbose(*debug)
+//
+// The q calls stay compiled into your program either way.
+func SetVerbose(verbose bool) {
+	std.quiet.Store(!verbose)
+}
+
+// SetRedaction turns redaction of sensitive values on or off. When it's on,
+// the values of headers like Authorization and Cookie are printed as
+// <redacted>. It's on by default.
+func SetRedaction(on bool) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.opts.showSecrets = !on
+}
+
+// SetWriteBOM makes q write a UTF-8 byte order mark at the start of the log
+// file whenever it creates the file, or finds that it has been truncated. Some
+// Windows tools need it to detect that the file is UTF-8. It's off by default.
+func SetWriteBOM(on bool) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.writeBOM = on
+}
+
+// SetHotSiteWarning makes q print a warning the first time a single line of
+// code calls q more than perSec times in one second. That's usually a q call
+// that was left in a tight loop by accident. 0, the default, disables it.
+func SetHotSiteWarning(perSec int) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.hotSitePPS = perSec
+}
+
+// SetShowPointerAddr makes q print the address of each pointer along with the
+// value it points to, e.g. 0xc000010000->main.User{ID:5}. Use it to see which
+// values are shared. It's off by default, so pointers print as &main.User{ID:5}.
+func SetShowPointerAddr(on bool) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.opts.showAddr = on
+}
+
+// SetOnCallerError sets a function that's called whenever q can't determine
+// which file, function, and line called it. That's rare, and usually means the
+// stack is very deep or something is wrong with the runtime. The values are
+// still logged, without names, on a line marked [caller unknown].
+//
+// f is called while q holds its lock, so it must not call q.
+func SetOnCallerError(f func(error)) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.onCallerErr = f
+}
+
+// SetSortFields makes q print struct fields sorted by name, instead of in the
+// order they're declared. Together with the sorted map keys, that makes dumps
+// of two versions of a struct easy to diff. It's off by default.
+func SetSortFields(on bool) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.opts.sortFields = on
+}
+
+// SetFile makes q write to f instead of $TMPDIR/q, e.g. to share a log file
+// your program already has open. q only borrows f: it never closes it, so it
+// must stay open for as long as q might write to it. Closing it is up to you,
+// after calling SetFile(nil) to switch back to $TMPDIR/q. Everything else
+// works as it does with $TMPDIR/q, e.g. SetWriteBOM() still checks whether f
+// is empty.
+func SetFile(f *os.File) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.file = f
+}
+
+// SetCompactStructs makes q print each struct on a single line, e.g.
+// main.User{ID:5, Name:"x", Addr:main.Addr{City:"Paris"}, Tags:{"a", "b"}},
+// instead of one field per line. Everything inside the struct, like nested
+// structs, maps, and slices, is printed on that line too, and q breaks the
+// log line between values as usual if it gets too long. Errors, url.Values,
+// and http.Header are still printed one item per line. It's off by default.
+func SetCompactStructs(on bool) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.opts.compact = on
+}
+
+// SetTimeZones makes q print each time.Time in all of the given zones, e.g.
+//
+//	q.SetTimeZones(time.UTC, time.Local)
+//
+// prints time.Time(2024-03-10 17:04:05 +0000 UTC / 2024-03-10 09:04:05 -0800 PST).
+// Seeing both side by side makes offset mistakes obvious. A nil location means
+// UTC. With no arguments, the default, each time is printed in its own
+// location.
+func SetTimeZones(locs ...*time.Location) {
+	zones := make([]*time.Location, len(locs))
+	for i, loc := range locs {
+		if loc == nil {
+			loc = time.UTC
+		}
+		zones[i] = loc
+	}
+
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.opts.timeZones = zones
+}
+
+// SetClock makes q get the current time from clock instead of time.Now. Use it
+// to make q's output deterministic in golden tests: the times in the headers,
+// and the timestamps on each line, all come from clock, and so does the 2s
+// pause that starts a new log group. Setting a clock starts a new group.
+// SetClock(nil) goes back to time.Now.
+func SetClock(clock func() time.Time) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.clock = clock
+	std.lastClock = time.Time{}
+	std.stats.start = std.now()
+}
+
+// SetPackageAbbreviation makes q shorten the package path of the function name
+// in headers to the first letter of each element, except the last one, e.g.
+// github.com/org/repo/internal/db.(*Conn).Query becomes
+// g/o/r/i/db.(*Conn).Query. It's off by default.
+func SetPackageAbbreviation(on bool) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.abbrev = on
+}
+
+// SetFileHeader makes q write the given line at the top of the log file
+// whenever it creates the file, or finds that it has been truncated, e.g. to
+// say which program the log belongs to. If SetWriteBOM() is on, the header
+// goes right after the BOM. "", the default, disables it.
+func SetFileHeader(header string) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.fileHeader = header
+}
+
+// SetShowBuildInfo makes q write a line describing the build of the program to
+// the log file: the main module's path and version, and the VCS revision it was
+// built from, with a note if the working tree had uncommitted changes. That
+// tells you which build produced a log someone sends you. The line is written
+// once per process, the first time it writes to the file, and after the
+// header whenever the file is created or truncated. If the binary has no build
+// info, the line says so. It's off by default.
+func SetShowBuildInfo(on bool) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.buildInfo = on
+}
+
+// AddColorRule adds a rule that picks the color of the values passed to Q()
+// and friends, to make anomalies stand out, e.g.
+//
+//	q.AddColorRule(func(v interface{}) (q.Color, bool) {
+//		d, ok := v.(time.Duration)
+//		return q.Yellow, ok && d > time.Second
+//	})
+//
+// The rules are checked in the order they were added, and the first one that
+// returns true decides the color. Values that no rule matches are red if they
+// are or hold a NaN or ±Inf float, which is usually the result of a bug, and
+// cyan otherwise. The rules are called while q holds its lock, so they must not call q.
+func AddColorRule(rule func(v interface{}) (Color, bool)) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.opts.colorRules = append(std.opts.colorRules, rule)
+}
+
+// SetQbufSize sets the number of calls to Qbuf() that q keeps in memory. When
+// there are more, the oldest ones are dropped. The default is 100. Values of n
+// less than 1 are treated as 1.
+func SetQbufSize(n int) {
+	if n < 1 {
+		n = 1
+	}
+
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.ringSize = n
+	std.trimRing()
+}
+
+// SetGroupMarker makes q write the given line before the header of each log
+// group, e.g. "--- q group ---". Unlike the blank line before each header, it
+// can't be mistaken for part of a logged value, so tools that process the log
+// file can split it into groups on that line. "", the default, disables it.
+func SetGroupMarker(marker string) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.groupMarker = marker
+}
+
+// SetFormatterVerb sets the verb q uses to print values that implement
+// fmt.Formatter, e.g. "%v" or "%#v". Those values are printed by their own
+// Format method rather than field by field, since that's how their author
+// meant them to be seen. The default is "%+v".
+func SetFormatterVerb(verb string) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.opts.formatterVerb = verb
+}
+
+// SetShowSequence makes q print each call's sequence number, e.g. #42, before
+// its values. See Sequence(). It's off by default.
+func SetShowSequence(on bool) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.showSeq = on
+}
+
+// SetHexDump makes q print byte slices as hexdumps, with the offset of each
+// row on the left and the printable characters on the right, instead of as a
+// list of numbers. It's off by default.
+func SetHexDump(on bool) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.opts.hexDump = on
+}
+
+// SetHexDumpWidth sets the number of bytes in each row of a hexdump, e.g. 8 or
+// 32 to line up with fixed-size binary records. See SetHexDump(). The default
+// is 16. Values of n less than 1 restore the default.
+func SetHexDumpWidth(n int) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.opts.hexWidth = n
+}
+
+// SetPerUserFile makes q write to $TMPDIR/q-<user> instead of $TMPDIR/q, where
+// <user> is the name of the current user, or their user ID if the name is
+// unknown. Use it on machines where several users share $TMPDIR, so they
+// don't clobber each other's logs. Even when it's off, the default, q falls
+// back to $TMPDIR/q-<user> if $TMPDIR/q belongs to someone else. A file set
+// with SetFile() takes precedence over both.
+func SetPerUserFile(on bool) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	if std.buf.Len() > 0 {
+		std.flush()
+	}
+	std.closeGzip()
+	std.perUser = on
+}
+
+// SetFlushEveryN makes q write its output to disk once every n calls, instead
+// of after every call, which is faster when q is called a lot. The catch is
+// that up to n-1 calls are lost if the program exits or crashes before they're
+// written, so call Flush() before exiting. Other output, like CaptureStd()
+// lines and QbufDump(), still writes everything right away. The default is 1.
+// Values of n less than 1 are treated as 1.
+func SetFlushEveryN(n int) {
+	if n < 1 {
+		n = 1
+	}
+
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.flushEvery = n
+}
+
+// SetShowSpawnSite makes q show where the calling goroutine was started in the
+// header, if it was started by q.Go(). It's off by default.
+func SetShowSpawnSite(on bool) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.showSpawn = on
+}
+
+// SetCompressed makes q write its output to $TMPDIR/q.gz, compressed as it's
+// written, instead of to $TMPDIR/q. It's for very long or verbose sessions,
+// and trades CPU for disk space. The file is kept open and written as a single
+// gzip stream, which isn't complete until Close() is called; until then, the
+// output so far can be read, e.g. with zcat, but it complains that the file
+// ends too soon. Each run of the program appends a new stream, which gzip
+// reads as one file. It's off by default.
+func SetCompressed(on bool) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	if std.buf.Len() > 0 {
+		std.flush()
+	}
+	std.closeGzip()
+	std.compressed = on
+}
+
+// SetTrimPrefix makes headers show the path of the calling file relative to
+// prefix, e.g. internal/db/conn.go, instead of just its directory and name,
+// e.g. db/conn.go. Set it to the root of your module, as the compiler sees
+// it: an absolute path, or the module path if the program was built with
+// -trimpath. Files outside of prefix are shown as usual. "", the default,
+// disables it.
+func SetTrimPrefix(prefix string) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.trimPrefix = prefix
+}
+
+// SetAdaptiveGrouping makes q start a new log group when the time since a call
+// site's previous call is much longer than usual for that site, instead of
+// after a fixed 2s pause. Each site keeps a moving average of the time between
+// its calls, and a gap more than 4 times that average, and at least 50ms,
+// starts a new group. That lines groups up with the natural pauses in bursty
+// workloads, where 2s can be far too long or too short. Until a site has been
+// called a few times, the 2s timer still applies. It's off by default.
+func SetAdaptiveGrouping(on bool) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.adaptive = on
+}
+
+// SetFlushRetries makes q retry writing its output to the log file up to n
+// times when a write fails, e.g. with EAGAIN or EINTR on a network or overlay
+// filesystem, instead of dropping the output. It waits backoff before the
+// first retry, and twice as long before each one after that. The caller of Q()
+// waits too, so keep both small. Only the output that hasn't been written yet
+// is retried, so nothing is written twice. If every retry fails, the output is
+// dropped, and Flush() returns the error. The default is no retries.
+func SetFlushRetries(n int, backoff time.Duration) {
+	if n < 0 {
+		n = 0
+	}
+
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.flushRetries = n
+	std.flushBackoff = backoff
+}
+
+// SetRepeatWindow makes q suppress a value that's logged from the same line
+// again within d of when it was first logged. When the window closes, q logs
+// the value once more, with the number of times it was suppressed. Unlike
+// QUnique(), the value is logged again once the window has passed, and calls
+// from other lines in between don't matter. Values are compared by their
+// pretty-printed form. 0, the default, disables it.
+//
+// The window is closed by a real timer, unless a clock is set with SetClock()
+// or test mode is on. Then it's closed by the next call from the same line, so
+// the output doesn't depend on real time, and a value's summary isn't written
+// until that line logs again.
+func SetRepeatWindow(d time.Duration) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.repeatWindow = d
+}
+
+// SetSnapshotDir makes q write the output of each call to Q() and friends to a
+// new file in dir, instead of appending it to the log file, e.g. to dump a
+// struct on each iteration of a loop and compare the dumps with a diff tool.
+// The files are named after the call's sequence number, e.g. 0042.txt; see
+// Sequence(). Each file starts with the call's header. The log file isn't
+// written while it's on, except by CaptureStd() and QbufDump(). dir is created
+// if needed. "", the default, disables it.
+func SetSnapshotDir(dir string) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.snapshotDir = dir
+}
+
+// SetLevelFile makes q write calls at the given level to the file at path,
+// instead of the log file, e.g. to keep errors in $TMPDIR/q.err where they're
+// easy to find:
+//
+//	q.SetLevelFile(q.LevelError, "q.err")
+//
+// Relative paths are relative to the directory of the log file. Calls at other
+// levels still go to the log file. Each call written to the level's file starts
+// with its header. Nothing is written to it while SetFileOutput() is off or
+// SetSnapshotDir() is on. "", the default, sends the level back to the log
+// file.
+func SetLevelFile(level Level, path string) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	if path == "" {
+		delete(std.levelFiles, level)
+		return
+	}
+	if std.levelFiles == nil {
+		std.levelFiles = make(map[Level]string)
+	}
+	std.levelFiles[level] = path
+}
+
+// SetMaxValueBytes makes q stream byte slices longer than n bytes to the log
+// file as a hexdump, a chunk at a time, instead of formatting them in memory
+// all at once, which takes several times their size. The call's line says how
+// big the slice is, and that the data is below it. Readers wrapped with
+// Stream() are always streamed. Values are only streamed when the call's
+// output goes to the log file, e.g. not with Qbuf() or SetSnapshotDir(). 0,
+// the default, disables it.
+func SetMaxValueBytes(n int) {
+	if n < 0 {
+		n = 0
+	}
+
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.maxValueBytes = n
+}
+
+// SetNameResolver sets a function that q asks for the names of the arguments
+// of a call, before it tries to find them in the source, e.g. for builds where
+// the source isn't available, or calls in generated code. resolve is given the
+// file and line of the call, and must return one name per argument, in order,
+// including the arguments that aren't values, like the note passed to Qn().
+// Arguments without a name, like literals, get "". If it returns an error, q
+// falls back to reading the source, as usual. It's called while q holds its
+// lock, so it must not call q. nil, the default, disables it.
+func SetNameResolver(resolve func(file string, line int) ([]string, error)) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.nameResolver = resolve
+}
+
+// SetEmptyCallMarker sets what calls without values, like q.Q(), print in
+// place of the values. Such calls are usually there to mark that a line was
+// reached, so the default is "•", after the timestamp as usual, e.g.
+//
+//	[14:00:36 main.go:122 main.main]
+//	0.000s •
+//
+// "" makes them print just the timestamp. Calls with a note, like Qn(), print
+// the note instead.
+func SetEmptyCallMarker(marker string) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.emptyMarker = marker
+}
+
+// SetAtomicWrite makes q replace the log file on each flush instead of
+// appending to it: the file's content and the new output are written to a
+// temporary file in the same directory, which is renamed over the log file.
+// Since the rename is atomic, programs that read the whole file never see a
+// partial write. The tradeoff is that each flush rewrites the whole file, so
+// it gets slower as the file grows, and the file is a new one after each
+// flush, so tail -f, which follows the old file, stops seeing new output; use
+// tail -F, and truncate the log now and then. A file set with SetFile(), and
+// the compressed log, are appended to as usual. It's off by default.
+func SetAtomicWrite(on bool) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.atomicWrite = on
+}
+
+// SetCorrelationKey sets the context key of the correlation ID, e.g. a request
+// or trace ID, that's shown in the headers of calls to QCtx(). Calls whose
+// context doesn't have one show id=-. Calls to the other functions, which
+// aren't given a context, aren't affected. nil, the default, disables it.
+func SetCorrelationKey(key interface{}) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.correlationKey = key
+}
+
+// AutoSessionID makes SetSessionID() generate a random session ID.
+const AutoSessionID = "auto"
+
+// SetSessionID makes q show the given ID in every header, e.g.
+//
+//	[14:00:36 sid=9f3a6c01 main.go:122 main.main]
+//
+// so the groups of one run can be picked out when several runs write to the
+// same log file. AutoSessionID generates a short random ID, which stays the same
+// for the life of the process. "", the default, disables it.
+func SetSessionID(id string) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.sessionID = id
+}
+
+// SetCurrentGroup makes q group calls under the given label, e.g. "phase-1",
+// instead of by the file and function they're from, until the label is
+// changed. The header shows the label in place of the file and function:
+//
+//	[14:00:36 phase-1]
+//
+// and a new group only starts when the label changes, or 2s after the last
+// call, as usual. Calls to QGroup() still start their own groups. The label is
+// global, not per goroutine, so calls from other goroutines that run at the
+// same time are grouped under it too. "", the default, goes back to grouping
+// by file and function.
+func SetCurrentGroup(label string) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	if label == std.currentGroup {
+		return
+	}
+	std.currentGroup = label
+	std.start = time.Time{}
+	std.timer.Stop()
+	std.lastFunc, std.lastFile = "", ""
+}
+
+// SetTestMode makes q's output byte-for-byte the same on every run, so it can
+// be compared with a golden file. It pins down everything that varies:
+//
+//   - The clock starts at 2000-01-01 00:00:00 UTC when test mode is turned on,
+//     and advances by exactly 1ms on each call to Q() and friends. It's used
+//     for the header times and line timestamps, in place of the real time or
+//     the clock set with SetClock(). Calls to QAt() still use their own time.
+//   - New log groups start only when the function or file changes, or on
+//     QGroup(), never because 2s of real time have passed.
+//   - The log file is written without colors, as with FormatPlain.
+//   - Sequence numbers aren't printed, even with SetShowSequence(), since
+//     they're shared by every test in the process.
+//   - The session ID generated for SetSessionID(AutoSessionID) is "test".
+//
+// Turning it on also starts a new log group. Use it in test helpers that check
+// q's output, and turn it off when the test is done:
+//
+//	q.SetTestMode(true)
+//	t.Cleanup(func() { q.SetTestMode(false) })
+func SetTestMode(on bool) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.testMode = on
+	std.testTime = testModeStart
+	std.stats.start = std.now()
+	std.start = time.Time{}
+	std.timer.Stop()
+	std.lastFunc, std.lastFile = "", ""
+}
+
+// SetBytesAsStringIfUTF8 makes q print byte slices that are valid UTF-8 as
+// quoted strings, e.g. []uint8("{\"ok\":true}"), and the others as hexdumps, so
+// each is shown the readable way, e.g. a response body that may or may not be
+// text. A call with WithBytesHex() prints a hexdump either way. It's off by
+// default.
+func SetBytesAsStringIfUTF8(on bool) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.opts.utf8Bytes = on
+}
+
+// SetAutoBackoff makes q back off from call sites that log too often, without
+// having to pick a sampling rate for each one. When a site is called more than
+// 100 times in a second, q logs only 1 in 2 of its calls, then 1 in 4, and so
+// on, as needed to stay around that rate. When the site quiets down, q goes
+// back to logging every call, in the same steps. Each change is noted in the
+// log. Unlike SetHotSiteWarning(), which only warns, this drops calls. It's off
+// by default.
+func SetAutoBackoff(on bool) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.autoBackoff = on
+}
+
+// SetComplexFormat sets how q prints complex numbers: in rectangular or polar
+// form, with prec digits after the decimal point, e.g. (1.00+2.00i) or
+// (2.24∠1.11) with a prec of 2. A prec less than 0 uses as many digits as
+// needed to represent the number exactly. Zero prints as (0+0i) or (0∠0), and
+// purely imaginary numbers as (0+2i) or (2∠1.5707963267948966), like any other
+// number. The default is ComplexRectangular with as many digits as needed,
+// which matches fmt.
+func SetComplexFormat(f ComplexFormat, prec int) {
+	if prec < 0 {
+		prec = -1
+	}
+
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.opts.complexForm = f
+	std.opts.complexPrec = prec + 1
+}
+
+// SetRunSummary makes Close() write a line summing up the run before it closes
+// the log file, e.g.
+//
+//	q run summary: 42 calls in 3 groups, 12.3 KiB written, ran for 1m2s
+//
+// to give a sense of the session's scope. The bytes are those written to the
+// log file before the summary, before compression. The run is timed with q's
+// clock, from when the program started, or from the last call to SetClock() or
+// SetTestMode(), so in test mode it's 1ms per call. Call Close() before the
+// program exits, e.g. with defer in main(), for the summary to be written. It's
+// off by default.
+func SetRunSummary(on bool) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.runSummary = on
+}
@@ -6,25 +6,52 @@ package q
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
 	"fmt"
+	"hash/fnv"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"runtime/debug"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
-type color string
+// Color is an ANSI color escape code. See AddColorRule().
+type Color string
+
+// Colors that values can be printed in. See AddColorRule().
+const (
+	Red     Color = "\033[31m"
+	Green   Color = "\033[32m"
+	Yellow  Color = "\033[33m"
+	Blue    Color = "\033[34m"
+	Magenta Color = "\033[35m"
+	Cyan    Color = "\033[36m"
+)
 
 const (
 	// ANSI color escape codes
-	bold     color = "\033[1m"
-	yellow   color = "\033[33m"
-	cyan     color = "\033[36m"
-	endColor color = "\033[0m" // "reset everything"
+	bold     Color = "\033[1m"
+	yellow         = Yellow
+	cyan           = Cyan
+	endColor Color = "\033[0m" // "reset everything"
 
 	maxLineWidth = 80
+
+	// callerUnknown marks log lines whose file, function, and line number
+	// couldn't be determined.
+	callerUnknown = "[caller unknown]"
 )
 
 // The q logger singleton
@@ -39,39 +66,322 @@ type logger struct {
 	timer    *time.Timer   // when it gets to 0, start a new log group
 	lastFile string        // last file to call q.Q(). determines when to print header
 	lastFunc string        // last function to call q.Q()
+
+	lastHeader    time.Time     // when the last header was printed
+	at            time.Time     // time given to QAt() for the call being printed. zero means now.
+	lastAt        time.Time     // at for the previous call
+	lastClock     time.Time     // clock time of the previous call, if there's a clock. see SetClock().
+	headerRefresh time.Duration // reprint the header at least this often. 0 means never.
+
+	quiet       atomic.Bool      // if true, log() does nothing. see SetVerbose().
+	opts        formatOptions    // how values are printed
+	writeBOM    bool             // start new log files with a UTF-8 BOM. see SetWriteBOM().
+	fileHeader  string           // first line of new log files. see SetFileHeader().
+	buildInfo   bool             // write the build info to the log file. see SetShowBuildInfo().
+	wroteBuild  bool             // true once the build info has been written by this process
+	format      Format           // layout of the log file
+	file        *os.File         // borrowed file to write to instead of $TMPDIR/q. see SetFile().
+	compressed  bool             // write to $TMPDIR/q.gz. see SetCompressed().
+	gz          *gzip.Writer     // the gzip stream, while $TMPDIR/q.gz is open
+	gzFile      *os.File         // $TMPDIR/q.gz, while it's open
+	perUser     bool             // write to $TMPDIR/q-<user>. see SetPerUserFile().
+	clock       func() time.Time // returns the current time. nil means time.Now. see SetClock().
+	abbrev      bool             // abbreviate package paths in headers. see SetPackageAbbreviation().
+	trimPrefix  string           // show file paths in headers relative to this. see SetTrimPrefix().
+	adaptive    bool             // start groups at each site's natural pauses. see SetAdaptiveGrouping().
+	groupMarker string           // line written before each header. see SetGroupMarker().
+	showSpawn   bool             // show where the goroutine was started in headers. see SetShowSpawnSite().
+	showSeq     bool             // print each call's sequence number. see SetShowSequence().
+
+	runSummary bool     // write a summary of the run on Close(). see SetRunSummary().
+	stats      runStats // what q has done so far
+
+	testMode bool      // make the output byte-stable. see SetTestMode().
+	testTime time.Time // the time according to the test mode clock
+
+	sessionID      string      // shown in every header. see SetSessionID().
+	currentGroup   string      // label that groups calls instead of their file and function. see SetCurrentGroup().
+	correlationKey interface{} // context key of the ID shown in the headers of QCtx() calls. see SetCorrelationKey().
+
+	onCallerErr func(error) // called when the caller info is unknown. see SetOnCallerError().
+
+	nameResolver func(file string, line int) ([]string, error) // finds the names of the arguments. see SetNameResolver().
+
+	sites       map[siteKey]*callSite // per call site state, e.g. call counts
+	hotSitePPS  int                   // warn about sites logging more than this per second. 0 means never.
+	autoBackoff bool                  // log 1 in n calls from sites that log too often. see SetAutoBackoff().
+
+	sinks      []sink                      // extra destinations for the output. see AddSink().
+	recordSink func(Record)                // called with the record of each call. see SetRecordSink().
+	forward    func(Level, string, []Pair) // called with the data of each call. see SetForward().
+	noFile     bool                        // don't write the log file. see SetFileOutput().
+	bodyStart  int                         // where the output of the call being printed starts in buf, after the header
+
+	snapshotDir string           // write each call to its own file in this directory. see SetSnapshotDir().
+	levelFiles  map[Level]string // write calls at these levels to these files. see SetLevelFile().
+
+	maxValueBytes int // stream byte slices longer than this to the log file. 0 means never. see SetMaxValueBytes().
+
+	flushEvery int // flush to disk once every this many calls. see SetFlushEveryN().
+	unflushed  int // number of calls in buf that haven't been flushed
+
+	flushRetries int           // times to retry a failed write. see SetFlushRetries().
+	atomicWrite  bool          // replace the log file on each flush. see SetAtomicWrite().
+	flushBackoff time.Duration // wait before the first retry
+
+	repeatWindow time.Duration // suppress values repeated within this long. see SetRepeatWindow().
+	emptyMarker  string        // printed by calls without values. see SetEmptyCallMarker().
+
+	seen       map[uint64]bool // hashes of the values logged by QUnique()
+	seenOrder  []uint64        // the hashes in seen, oldest first
+	suppressed int             // calls to QUnique() skipped since the last one logged
+
+	ring     []string // output of the latest calls to Qbuf(), oldest first
+	ringSize int      // max number of calls kept in ring. see SetQbufSize().
+}
+
+// siteKey identifies a call site, i.e. a line of source code that calls q.
+type siteKey struct {
+	file string
+	line int
+}
+
+// callSite is what q keeps track of for each call site.
+type callSite struct {
+	windowStart time.Time // start of the current 1s window
+	windowCalls int       // number of calls since windowStart
+	warnedHot   bool      // true if a hot site warning has been printed
+
+	lastCall time.Time     // time of the previous call. see SetAdaptiveGrouping().
+	avgGap   time.Duration // moving average of the time between calls
+
+	prevSlice []string     // formatted elements of the last slice. see QSliceDiff().
+	prevType  reflect.Type // type of the last slice
+
+	repeats map[uint64]*repeat // values logged in the current repeat window. see SetRepeatWindow().
+	backoff backoff            // see SetAutoBackoff().
 }
 
 // init creates the standard logger.
 func init() {
+	std = newLogger()
+}
+
+// newLogger returns a logger with an empty buffer and a stopped timer.
+func newLogger() *logger {
 	// Starting with 0 time doesn't mean the timer is stopped, so we must
 	// explicitly stop the timer.
 	t := time.NewTimer(0)
 	t.Stop()
 
-	std = &logger{
-		buf:   &bytes.Buffer{},
-		timer: t,
+	l := &logger{
+		buf:         &bytes.Buffer{},
+		timer:       t,
+		sites:       make(map[siteKey]*callSite),
+		ringSize:    defaultQbufSize,
+		flushEvery:  1,
+		emptyMarker: defaultEmptyMarker,
 	}
+	l.stats.start = l.now()
+	return l
+}
+
+// call describes a single call to one of q's exported logging functions.
+type call struct {
+	funcName  string          // function that called q, e.g. main.main
+	file      string          // file that called q
+	line      int             // line that called q
+	callerErr error           // non-nil if funcName, file, and line are unknown
+	note      string          // printed above the values. see Qn().
+	group     string          // forces a header with this name. see QGroup().
+	fields    []string        // paths of the struct fields in values. see QFields().
+	names     []string        // names of the values, instead of the source. see QReturn().
+	lines     bool            // print each value on its own line. see QEnv().
+	buffered  bool            // keep the output in memory instead of writing it. see Qbuf().
+	seq       uint64          // position of the call in the process-wide sequence. see Sequence().
+	level     Level           // severity of the call
+	at        time.Time       // when the call happened, if not now. see QAt().
+	unique    bool            // only log values that haven't been logged before. see QUnique().
+	sliceDiff bool            // log how the slice in values changed. see QSliceDiff().
+	options   []Option        // how to print the values, on top of the global settings. see QOpt().
+	ctx       context.Context // the context the call was made in. see QCtx().
+	inplace   bool            // overwrite the previous in-place line on terminals. see QInplace().
+	sizeof    bool            // show how much memory each value takes up. see QSizeof().
+	skip      int             // number of leading arguments in the source that aren't values
+	values    []interface{}   // the values to pretty-print
 }
 
 // header returns a formatted header string, e.g. [14:00:36 main.go main.main:122]
 // if the 2s timer has expired, or the calling function or filename has changed.
-// If none of those things are true, it returns an empty string.
+// It also returns a header if the header refresh interval has passed since the
+// last one. If none of those things are true, it returns an empty string. If
+// file is empty, e.g. for output captured by CaptureStd(), the header is just
+// the time and funcName.
 func (l *logger) header(funcName, file string, line int) string {
 	// Reset the 2s timer.
 	timerExpired := l.resetTimer(2 * time.Second)
+	if l.testMode {
+		// Real time would make the output depend on how fast the test runs.
+		timerExpired = l.start.IsZero()
+	} else if l.clock != nil && l.at.IsZero() {
+		// With a clock set, the pause is measured with it too, like the
+		// times in the output, so golden tests don't depend on real time.
+		now := l.now()
+		gap := now.Sub(l.lastClock)
+		timerExpired = l.start.IsZero() || l.lastClock.IsZero() || gap < 0 || gap >= 2*time.Second
+		l.lastClock = now
+	}
+
+	if l.adaptive && file != "" {
+		if expired, ok := l.adaptiveExpired(file, line); ok {
+			timerExpired = expired
+		}
+	}
+
+	// The timer measures real time, which means nothing for calls to QAt().
+	// They start a new group when their own times are 2s or more apart, or out
+	// of order. Switching between QAt() and the clock starts one too.
+	if !l.at.IsZero() || !l.lastAt.IsZero() {
+		gap := l.at.Sub(l.lastAt)
+		timerExpired = l.at.IsZero() || l.lastAt.IsZero() || gap < 0 || gap >= 2*time.Second
+	}
+	l.lastAt = l.at
+	if timerExpired {
+		l.start = l.now()
+	}
 
-	if !timerExpired && funcName == l.lastFunc && file == l.lastFile {
+	refresh := l.headerRefresh > 0 && l.now().Sub(l.lastHeader) >= l.headerRefresh
+
+	if !timerExpired && !refresh && funcName == l.lastFunc && file == l.lastFile {
 		// Don't print a header line.
 		return ""
 	}
 
 	l.lastFunc = funcName
 	l.lastFile = file
+	l.lastHeader = l.now()
 
-	now := time.Now().UTC().Format("15:04:05")
+	now := l.lastHeader.UTC().Format("15:04:05")
+	if id := l.session(); id != "" {
+		now += " sid=" + id
+	}
 
-	return fmt.Sprintf("[%s %s:%d %s]", now, shortFile(file), line, funcName)
+	if file == "" {
+		return fmt.Sprintf("[%s %s]", now, funcName)
+	}
+	return fmt.Sprintf("[%s %s:%d %s]", now, l.displayFile(file), line, funcName)
+}
+
+// session returns the session ID to show in headers, or "" if there isn't one.
+// See SetSessionID().
+func (l *logger) session() string {
+	if l.sessionID != AutoSessionID {
+		return l.sessionID
+	}
+	if l.testMode {
+		return "test"
+	}
+	return autoSessionID()
+}
+
+var (
+	sessionOnce sync.Once
+	sessionID   string
+)
+
+// autoSessionID returns the random session ID of the process, generating it
+// the first time it's called.
+func autoSessionID() string {
+	sessionOnce.Do(func() {
+		b := make([]byte, 4)
+		if _, err := rand.Read(b); err != nil {
+			// Not random, but still likely to differ between runs.
+			binary.BigEndian.PutUint32(b, uint32(os.Getpid())^uint32(time.Now().UnixNano()))
+		}
+		sessionID = hex.EncodeToString(b)
+	})
+	return sessionID
+}
+
+// displayFile returns the name of the file to show in headers: the path
+// relative to the prefix set with SetTrimPrefix(), if the file is under it, or
+// else the <directory>/<file>.
+func (l *logger) displayFile(file string) string {
+	if l.trimPrefix != "" {
+		prefix := strings.TrimSuffix(l.trimPrefix, "/") + "/"
+		if strings.HasPrefix(file, prefix) {
+			return file[len(prefix):]
+		}
+	}
+	return shortFile(file)
+}
+
+const (
+	// adaptiveFactor is how many times longer than usual the gap before a call
+	// has to be to start a new group. See SetAdaptiveGrouping().
+	adaptiveFactor = 4
+
+	// adaptiveMinGap is the shortest gap that starts a new group, so sites
+	// that are called in a tight loop don't get a header for every hiccup.
+	adaptiveMinGap = 50 * time.Millisecond
+
+	// adaptiveWeight is the weight of the latest gap in the moving average.
+	adaptiveWeight = 0.25
+)
+
+// adaptiveExpired decides whether a call from the given site starts a new
+// group, by comparing the time since the site's previous call to the average
+// time between its calls. ok is false if the site doesn't have an average yet,
+// so the 2s timer decides.
+func (l *logger) adaptiveExpired(file string, line int) (expired, ok bool) {
+	s := l.site(file, line)
+	now := l.now()
+	defer func() { s.lastCall = now }()
+
+	if s.lastCall.IsZero() {
+		return false, false
+	}
+	gap := now.Sub(s.lastCall)
+
+	if s.avgGap == 0 {
+		s.avgGap = gap
+		return false, false
+	}
+	expired = gap >= adaptiveMinGap && gap > adaptiveFactor*s.avgGap
+
+	// Pauses don't count towards the average, or a few of them would make it
+	// too long to notice the next one.
+	if !expired {
+		s.avgGap += time.Duration(adaptiveWeight * float64(gap-s.avgGap))
+	}
+	return expired, true
+}
+
+// plain returns true if the log file is written without colors, i.e. in
+// FormatPlain, or in test mode.
+func (l *logger) plain() bool {
+	return l.format == FormatPlain || l.testMode
+}
+
+// testModeStart is the time of the test mode clock when it's turned on.
+var testModeStart = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// testModeTick is how much the test mode clock advances on each call.
+const testModeTick = time.Millisecond
+
+// now returns the current time according to the logger's clock, or the time
+// of the call being printed, if it was given to QAt().
+func (l *logger) now() time.Time {
+	if !l.at.IsZero() {
+		return l.at
+	}
+	if l.testMode {
+		return l.testTime
+	}
+	if l.clock == nil {
+		return time.Now()
+	}
+	return l.clock()
 }
 
 // shortFile takes an absolute file path and returns just the <directory>/<file>,
@@ -82,34 +392,288 @@ func shortFile(file string) string {
 	return filepath.Join(dir, file)
 }
 
+// abbreviatePackage shortens each element of the package path in a function
+// name to its first letter, except the last one, e.g.
+// github.com/org/repo/db.(*Conn).Query -> g/o/r/db.(*Conn).Query.
+func abbreviatePackage(funcName string) string {
+	i := strings.LastIndex(funcName, "/")
+	if i < 0 {
+		return funcName
+	}
+
+	elems := strings.Split(funcName[:i], "/")
+	for j, e := range elems {
+		if r, size := utf8.DecodeRuneInString(e); size > 0 {
+			elems[j] = string(r)
+		}
+	}
+	return strings.Join(elems, "/") + funcName[i:]
+}
+
 // resetTimer resets the logger's timer to the given time. It returns true if
 // the timer had expired before it was reset.
 func (l *logger) resetTimer(d time.Duration) (expired bool) {
-	expired = !l.timer.Reset(d)
-	if expired {
-		l.start = time.Now()
-	}
-	return expired
+	return !l.timer.Reset(d)
 }
 
 // flush writes the logger's buffer to disk.
 func (l *logger) flush() error {
-	path := filepath.Join(os.TempDir(), "q")
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if l.atomicWrites() {
+		return l.flushAtomic()
+	}
+
+	w, done, err := l.logWriter()
 	if err != nil {
-		return fmt.Errorf("failed to open %q: %v", path, err)
+		return err
 	}
-	defer f.Close()
+	defer done()
 
-	_, err = io.Copy(f, l.buf)
+	b := l.buf.Bytes()
+	if l.plain() {
+		b = []byte(stripColor(l.buf.String()))
+	}
+
+	err = l.writeRetry(w, b)
 	l.buf.Reset()
-	return fmt.Errorf("failed to flush q buffer: %v", err)
+	l.unflushed = 0
+	if err == nil {
+		l.stats.bytes += int64(len(b))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to flush q buffer: %v", err)
+	}
+	return nil
+}
+
+// logWriter returns the writer that writes to the log file: the file set with
+// SetFile(), the gzip stream, or $TMPDIR/q, which is opened for the write. The
+// top of the file has been written if it's new; see startFile(). done must be
+// called when the write is over.
+func (l *logger) logWriter() (w io.Writer, done func(), err error) {
+	f, done := l.file, func() {}
+	w = f
+	switch {
+	case f != nil:
+	case l.compressed:
+		if err := l.openGzip(); err != nil {
+			return nil, nil, err
+		}
+		f, w = l.gzFile, l.gz
+	default:
+		f, err = l.openFile()
+		if err != nil {
+			return nil, nil, err
+		}
+		w, done = f, func() { f.Close() }
+	}
+
+	if err := l.startFile(f, w); err != nil {
+		done()
+		return nil, nil, err
+	}
+	return w, done, nil
+}
+
+// writeRetry writes b to w. If that fails, it retries up to flushRetries
+// times, waiting flushBackoff before the first retry, and twice as long before
+// each one after that. Only what hasn't been written yet is retried. See
+// SetFlushRetries().
+func (l *logger) writeRetry(w io.Writer, b []byte) error {
+	backoff := l.flushBackoff
+	for retry := 0; ; retry++ {
+		n, err := w.Write(b)
+		b = b[n:]
+		if err == nil && w == l.gz {
+			// Make what's been written so far readable, without ending the
+			// stream.
+			err = l.gz.Flush()
+		}
+		if err == nil || retry >= l.flushRetries {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// sync flushes the logger's buffer to disk, once any call that's writing to
+// it is done. See Flush().
+func (l *logger) sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.flush()
+}
+
+// openFile opens the log file for appending, creating it if needed. The log
+// file is $TMPDIR/q, or $TMPDIR/q-<user> with SetPerUserFile(), with a .gz
+// extension with SetCompressed(). If $TMPDIR/q belongs to another user, e.g.
+// because $TMPDIR is shared, q falls back to $TMPDIR/q-<user> rather than
+// failing.
+func (l *logger) openFile() (*os.File, error) {
+	const flags = os.O_CREATE | os.O_APPEND | os.O_WRONLY
+
+	ext := ""
+	if l.compressed {
+		ext = ".gz"
+	}
+
+	path := filepath.Join(os.TempDir(), "q")
+	if l.perUser {
+		path += "-" + userName()
+	}
+
+	f, err := os.OpenFile(path+ext, flags, 0600)
+	if os.IsPermission(err) && !l.perUser {
+		path += "-" + userName()
+		f, err = os.OpenFile(path+ext, flags, 0600)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %q: %v", path+ext, err)
+	}
+	return f, nil
+}
+
+// close flushes the logger's buffer to disk, and closes the log file if it's
+// kept open. See Close().
+func (l *logger) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.runSummary {
+		l.writeRunSummary()
+	}
+	err := l.flush()
+	if cerr := l.closeGzip(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// runStats are counts of what q has done since the program started. See
+// SetRunSummary().
+type runStats struct {
+	start  time.Time // when the logger was created
+	calls  int       // calls to Q() and friends
+	groups int       // log groups started, i.e. headers written
+	bytes  int64     // bytes written to the log file, before compression
+
+	anomalies int // NaNs and ±Infs logged. see SetRunSummary().
+}
+
+// writeRunSummary writes a line summing up the run to the log buffer, e.g.
+//
+//	q run summary: 42 calls in 3 groups, 12.3 KiB written, ran for 1m2s
+//
+// If any NaNs or ±Infs were logged, it says how many.
+func (l *logger) writeRunSummary() {
+	// Count the output that's about to be written with the summary too.
+	pending := l.buf.String()
+	if l.plain() {
+		pending = stripColor(pending)
+	}
+	written := l.stats.bytes + int64(len(pending))
+
+	ran := l.now().Sub(l.stats.start).Round(time.Millisecond)
+	summary := fmt.Sprintf("q run summary: %d calls in %d groups, %s written, ran for %v",
+		l.stats.calls, l.stats.groups, byteSize(written), ran)
+	if l.stats.anomalies > 0 {
+		summary += fmt.Sprintf(", %d NaN/Inf values", l.stats.anomalies)
+	}
+	fmt.Fprint(l.buf, "\n", colorize(summary, bold), "\n")
+}
+
+// maybeFlush counts a call, and flushes the logger's buffer to disk if there
+// have been flushEvery calls since the last flush. See SetFlushEveryN().
+func (l *logger) maybeFlush() {
+	if l.noFile || l.snapshotDir != "" {
+		return
+	}
+
+	l.unflushed++
+	if l.unflushed >= l.flushEvery {
+		l.flush()
+	}
+}
+
+// utf8BOM is the UTF-8 byte order mark. See SetWriteBOM().
+const utf8BOM = "\ufeff"
+
+// startFile writes whatever belongs at the top of a log file to w, if f is
+// empty, i.e. it was just created or has been truncated: the BOM, the file
+// header, then the build info. The build info is also written the first time
+// the process writes to a file that isn't empty, so each run's output can be
+// told apart. w is f, or the gzip stream that writes to it.
+func (l *logger) startFile(f *os.File, w io.Writer) error {
+	if !l.writeBOM && l.fileHeader == "" && !l.buildInfo {
+		return nil
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %q: %v", f.Name(), err)
+	}
+	empty := fi.Size() == 0
+	if !empty && (!l.buildInfo || l.wroteBuild) {
+		return nil
+	}
+
+	var start string
+	if empty && l.writeBOM {
+		start += utf8BOM
+	}
+	if empty && l.fileHeader != "" {
+		start += l.fileHeader + "\n"
+	}
+	if l.buildInfo {
+		start += formatBuildInfo(debug.ReadBuildInfo()) + "\n"
+	}
+	if _, err := io.WriteString(w, start); err != nil {
+		return fmt.Errorf("failed to write to %q: %v", f.Name(), err)
+	}
+	l.wroteBuild = l.buildInfo
+	return nil
+}
+
+// formatBuildInfo returns the line describing the build that SetShowBuildInfo()
+// writes to the log file, e.g.
+//
+//	build: github.com/me/server v1.4.0, revision 3f2a9c1e, dirty
+//
+// The revision and dirty flag are only known if the binary was built with VCS
+// stamping, i.e. by go build inside a repo.
+func formatBuildInfo(bi *debug.BuildInfo, ok bool) string {
+	if !ok || bi == nil {
+		return "build: no build info"
+	}
+
+	path, version := bi.Main.Path, bi.Main.Version
+	if path == "" {
+		path = bi.Path
+	}
+	if path == "" {
+		path = "unknown"
+	}
+	if version == "" {
+		version = "(devel)"
+	}
+
+	s := "build: " + path + " " + version
+	for _, kv := range bi.Settings {
+		switch {
+		case kv.Key == "vcs.revision":
+			s += ", revision " + kv.Value
+		case kv.Key == "vcs.modified" && kv.Value == "true":
+			s += ", dirty"
+		}
+	}
+	return s
 }
 
 // output writes to the log buffer. Each log message is prepended with a
 // timestamp. Long lines are broken at 80 characters.
 func (l *logger) output(args ...string) {
-	timestamp := fmt.Sprintf("%.3fs", time.Since(l.start).Seconds())
+	timestamp := fmt.Sprintf("%.3fs", l.now().Sub(l.start).Seconds())
 	timestampWidth := len(timestamp) + 1 // +1 for padding space after timestamp
 	timestamp = colorize(timestamp, yellow)
 
@@ -145,37 +709,933 @@ func (l *logger) output(args ...string) {
 	fmt.Fprint(l.buf, "\n")
 }
 
-// Q pretty-prints the given arguments to the $TMPDIR/q log file.
-func Q(v ...interface{}) {
-	std.mu.Lock()
-	defer std.mu.Unlock()
+// log writes the call to the log buffer and flushes it to disk.
+func (l *logger) log(c call) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-	// Flush the buffered writes to disk.
-	defer std.flush()
+	if l.quiet.Load() {
+		return
+	}
 
-	args := formatArgs(v...)
-	funcName, file, line, err := getCallerInfo()
-	if err != nil {
-		std.output(args...) // no name=value printing
+	c.seq = atomic.AddUint64(&seq, 1)
+	l.stats.calls++
+	if l.testMode {
+		l.testTime = l.testTime.Add(testModeTick)
+	}
+
+	streams := l.streamValues(&c)
+
+	if c.buffered {
+		l.printToRing(c)
 		return
 	}
 
-	// Print a header line if this q.Q() call is in a different file or
-	// function than the previous q.Q() call, or if the 2s timer expired.
-	// A header line looks like this: [14:00:36 main.go main.main:122].
-	header := std.header(funcName, file, line)
-	if header != "" {
-		fmt.Fprint(std.buf, "\n", header, "\n")
+	// Flush the buffered writes to disk, if it's time.
+	defer l.maybeFlush()
+
+	levelFile := l.levelFiles[c.level]
+	if l.noFile || l.snapshotDir != "" {
+		levelFile = ""
+	}
+	if l.snapshotDir != "" || levelFile != "" {
+		// Each snapshot stands on its own, so it needs a header. So does a
+		// call that goes to its level's file, since the calls before it went
+		// to another file.
+		l.lastFunc, l.lastFile = "", ""
 	}
 
-	// q.Q(foo, bar, baz) -> []string{"foo", "bar", "baz"}
-	names, err := argNames(file, line)
+	start := l.buf.Len()
+	rec := l.print(c)
+	if rec != nil && len(l.sinks) > 0 {
+		l.writeSinks(l.buf.String()[start:], l.buf.String()[l.bodyStart:], c.inplace, *rec)
+	}
+	if l.buf.Len() > start && l.snapshotDir != "" {
+		l.writeSnapshot(c.seq, l.buf.String()[start:])
+	}
+	if l.buf.Len() > start && levelFile != "" {
+		l.writeLevelFile(levelFile, l.buf.String()[start:])
+		l.buf.Truncate(start)
+		// The next call written to the log file needs a header too.
+		l.lastFunc, l.lastFile = "", ""
+	}
+	if l.noFile || l.snapshotDir != "" {
+		l.buf.Truncate(start)
+	}
+	if len(streams) > 0 && l.buf.Len() > start {
+		// The call's line goes first, then the streamed values.
+		l.flush()
+		for _, v := range streams {
+			l.writeStream(v)
+		}
+	}
+}
+
+// writeLevelFile appends the output of a call to the file SetLevelFile() set
+// for its level. Relative paths are relative to the directory of the log file.
+// Errors are ignored, as they are when writing the log file.
+func (l *logger) writeLevelFile(path, text string) {
+	if l.plain() {
+		text = stripColor(text)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(os.TempDir(), path)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
 	if err != nil {
-		std.output(args...) // no name=value printing
 		return
 	}
+	defer f.Close()
+	if err := l.writeRetry(f, []byte(text)); err == nil {
+		l.stats.bytes += int64(len(text))
+	}
+}
+
+// writeSnapshot writes the output of a call to its own file in the snapshot
+// directory, named after the call's sequence number. See SetSnapshotDir().
+// Errors are ignored, as they are when writing the log file.
+func (l *logger) writeSnapshot(seq uint64, text string) {
+	if l.plain() {
+		text = stripColor(text)
+	}
+	if err := os.MkdirAll(l.snapshotDir, 0700); err != nil {
+		return
+	}
+	path := filepath.Join(l.snapshotDir, fmt.Sprintf("%04d.txt", seq))
+	ioutil.WriteFile(path, []byte(strings.TrimPrefix(text, "\n")), 0600)
+}
+
+// seq is the sequence number of the last call logged by any logger in the
+// process. See Sequence().
+var seq uint64
+
+// Sequence returns the sequence number of the last call that was logged. Each
+// call takes the next number from a single counter shared by the whole process,
+// so the numbers give the exact order calls were logged in, even when their
+// timestamps are equal, e.g. when merging logs. See SetShowSequence().
+func Sequence() uint64 {
+	return atomic.LoadUint64(&seq)
+}
+
+// defaultEmptyMarker is what calls without values print, unless another marker
+// is set with SetEmptyCallMarker().
+const defaultEmptyMarker = "•"
+
+// defaultQbufSize is the number of calls to Qbuf() that are kept by default.
+const defaultQbufSize = 100
+
+// printToRing prints the call to the ring buffer instead of the log buffer. It
+// always gets a header, since it's written to the file out of order, and the
+// calls it was printed after may have been dropped from the ring by then.
+func (l *logger) printToRing(c call) {
+	buf := l.buf
+	l.buf = &bytes.Buffer{}
+	defer func() { l.buf = buf }()
+
+	l.lastFunc, l.lastFile = "", ""
+	l.print(c)
+	l.lastFunc, l.lastFile = "", ""
+
+	l.ring = append(l.ring, l.buf.String())
+	l.trimRing()
+}
+
+// trimRing drops the oldest calls from the ring buffer until it fits in
+// ringSize.
+func (l *logger) trimRing() {
+	if n := len(l.ring) - l.ringSize; n > 0 {
+		l.ring = append(l.ring[:0], l.ring[n:]...)
+	}
+}
+
+// dumpRing writes the calls in the ring buffer to disk, and empties it.
+func (l *logger) dumpRing() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.ring) == 0 {
+		return
+	}
+
+	// Flush the buffered writes to disk.
+	defer l.flush()
+
+	for _, s := range l.ring {
+		l.buf.WriteString(s)
+	}
+	l.ring = nil
+
+	// The next call needs a header to separate it from the dump.
+	l.lastFunc, l.lastFile = "", ""
+}
+
+// print writes the call to the log buffer as name=value pairs, preceded by a
+// header line if this call starts a new log group. If there are sinks, it
+// returns the record of the call for them. It returns nil if the call wasn't
+// printed.
+func (l *logger) print(c call) *Record {
+	l.at = c.at
+	defer func() { l.at = time.Time{} }()
+
+	if l.autoBackoff && c.callerErr == nil && l.backOff(c) {
+		return nil
+	}
+
+	if c.sliceDiff {
+		l.diffSlice(&c)
+	}
+
+	opts := callOptions(l.opts, c.options)
+	opts.anomalies = &l.stats.anomalies
+	opts.now = l.now
+	args := formatArgs(opts, c.values...)
+	if c.sizeof {
+		for i, v := range c.values {
+			args[i] = colorize("~"+byteSize(sizeof(v)), bold) + " " + args[i]
+		}
+	}
+
+	if c.unique && l.seenBefore(args) {
+		l.suppressed++
+		return nil
+	}
+
+	names := c.names
+	if names == nil && c.callerErr == nil {
+		// q.Q(foo, bar, baz) -> []string{"foo", "bar", "baz"}. If the source
+		// can't be parsed, the values are printed without names.
+		if n, err := l.argNames(c.file, c.line); err == nil && len(n) >= c.skip {
+			names = disambiguate(n[c.skip:])
+		}
+	}
+	if c.fields != nil {
+		names = fieldNames(names, c.fields)
+	}
+
+	if l.repeatWindow > 0 && c.callerErr == nil && l.isRepeat(c, names, args) {
+		return nil
+	}
+
+	var rec *Record
+	if len(l.sinks) > 0 || l.recordSink != nil || l.forward != nil {
+		r := l.record(c, names, args)
+		rec = &r
+		if l.recordSink != nil {
+			l.recordSink(r)
+		}
+		if l.forward != nil {
+			l.forward(r.Level, forwardMsg(r), r.Pairs)
+		}
+	}
+
+	l.printHeader(c)
+	l.bodyStart = l.buf.Len()
+
+	if c.unique && l.suppressed > 0 {
+		l.output(colorize(fmt.Sprintf("(seen before, suppressed %d)", l.suppressed), bold))
+		l.suppressed = 0
+	}
+
+	if c.callerErr == nil {
+		l.countCall(c.file, c.line)
+	}
+
+	if c.note != "" {
+		l.output(colorize(c.note, bold))
+	}
+
+	if len(args) == 0 && c.note == "" && l.emptyMarker != "" {
+		// Nothing to print, e.g. q.Q() used to mark that a line was reached.
+		args = []string{colorize(l.emptyMarker, bold)}
+	}
 
 	// Convert the arguments to name=value strings.
 	args = prependArgName(names, args)
-	std.output(args...)
+
+	// prefix goes before the first value.
+	var prefix []string
+	if l.showSeq && !l.testMode {
+		prefix = append(prefix, colorize(fmt.Sprintf("#%d", c.seq), yellow))
+	}
+	if c.callerErr != nil {
+		if l.onCallerErr != nil {
+			l.onCallerErr(c.callerErr)
+		}
+		prefix = append(prefix, colorize(callerUnknown, bold))
+	}
+
+	if !c.lines {
+		l.output(append(prefix, args...)...)
+		return rec
+	}
+	for i, arg := range args {
+		if i == 0 {
+			l.output(append(prefix, arg)...)
+			continue
+		}
+		l.output(arg)
+	}
+	return rec
+}
+
+// maxSeen is the number of distinct values QUnique() remembers. When there are
+// more, the oldest are forgotten, and may be logged again.
+const maxSeen = 10000
+
+// seenBefore returns true if the given formatted values have been logged by
+// QUnique() before. If not, it remembers them.
+func (l *logger) seenBefore(args []string) bool {
+	sum := hashArgs(args)
+
+	if l.seen[sum] {
+		return true
+	}
+
+	if l.seen == nil {
+		l.seen = make(map[uint64]bool)
+	}
+	if len(l.seenOrder) == maxSeen {
+		delete(l.seen, l.seenOrder[0])
+		l.seenOrder = l.seenOrder[1:]
+	}
+	l.seen[sum] = true
+	l.seenOrder = append(l.seenOrder, sum)
+	return false
+}
+
+// hashArgs returns a hash of the given formatted values.
+func hashArgs(args []string) uint64 {
+	h := fnv.New64a()
+	for _, arg := range args {
+		io.WriteString(h, arg)
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
+
+// printHeader writes a header line to the log buffer if this call is in a
+// different file or function than the previous call, or if the 2s timer
+// expired. A header line looks like this: [14:00:36 main.go main.main:122].
+// Calls to QGroup() always get a header with the group name in it.
+func (l *logger) printHeader(c call) {
+	var header string
+	switch {
+	case c.group == "" && l.currentGroup != "":
+		// The calls are grouped by the label, wherever they're from.
+		header = l.header(l.currentGroup, "", 0)
+	case c.group != "":
+		// Start a new group, and make sure the call after this one starts a
+		// new group too.
+		l.start = l.now()
+		l.lastFunc, l.lastFile = "", ""
+		header = l.header(c.group, c.file, c.line)
+		l.lastFunc, l.lastFile = "", ""
+	case c.callerErr == nil:
+		funcName := c.funcName
+		if l.abbrev {
+			funcName = abbreviatePackage(funcName)
+		}
+		if c.ctx != nil && l.correlationKey != nil {
+			funcName += " id=" + correlationID(c.ctx, l.correlationKey)
+		}
+		if l.showSpawn {
+			if site := spawnSite(); site != "" {
+				funcName += " (started at " + site + ")"
+			}
+		}
+		header = l.header(funcName, c.file, c.line)
+	}
+
+	if header != "" {
+		l.writeHeader(header)
+	}
+}
+
+// writeHeader writes the header line that starts a new log group to the log
+// buffer, preceded by a blank line and the group marker, if there is one.
+func (l *logger) writeHeader(header string) {
+	if l.plain() {
+		// Without colors, the header needs something else to stand out.
+		header = "-- " + header + " "
+		if n := maxLineWidth - len(header); n > 0 {
+			header += strings.Repeat("-", n)
+		}
+	}
+
+	l.stats.groups++
+	fmt.Fprint(l.buf, "\n")
+	if l.groupMarker != "" {
+		fmt.Fprint(l.buf, l.groupMarker, "\n")
+	}
+	fmt.Fprint(l.buf, header, "\n")
+}
+
+// site returns the state for the given call site, creating it if needed.
+func (l *logger) site(file string, line int) *callSite {
+	k := siteKey{file, line}
+	s, ok := l.sites[k]
+	if !ok {
+		s = &callSite{}
+		l.sites[k] = s
+	}
+	return s
+}
+
+// countCall counts a call from the given site. If the site makes more calls in
+// one second than allowed by SetHotSiteWarning(), it prints a warning, once.
+func (l *logger) countCall(file string, line int) {
+	if l.hotSitePPS <= 0 {
+		return
+	}
+
+	s := l.site(file, line)
+	now := l.now()
+	if now.Sub(s.windowStart) >= time.Second {
+		s.windowStart = now
+		s.windowCalls = 0
+	}
+	s.windowCalls++
+
+	if s.windowCalls > l.hotSitePPS && !s.warnedHot {
+		s.warnedHot = true
+		msg := fmt.Sprintf("q: %s:%d was called more than %d times in one second. Is it in a hot loop?",
+			l.displayFile(file), line, l.hotSitePPS)
+		l.output(colorize(msg, bold))
+	}
+}
+
+// Q pretty-prints the given arguments to the $TMPDIR/q log file.
+func Q(v ...interface{}) {
+	if std.quiet.Load() {
+		return
+	}
+	funcName, file, line, err := getCallerInfo()
+	std.log(call{
+		funcName:  funcName,
+		file:      file,
+		line:      line,
+		callerErr: err,
+		values:    v,
+	})
+}
+
+// Qn is like Q, but it first prints the given note in bold. Use it to explain
+// why you're logging the values, e.g. q.Qn("after retry", resp, err). The note
+// is not treated as one of the values.
+func Qn(note string, v ...interface{}) {
+	if std.quiet.Load() {
+		return
+	}
+	funcName, file, line, err := getCallerInfo()
+	std.log(call{
+		funcName:  funcName,
+		file:      file,
+		line:      line,
+		callerErr: err,
+		note:      note,
+		skip:      1,
+		values:    v,
+	})
+}
+
+// QInfo is like Q, but the call is at LevelInfo instead of LevelDebug. The
+// level decides where the call goes with SetLevelFile(), and is passed on to
+// SetRecordSink() and SetForward().
+func QInfo(v ...interface{}) {
+	if std.quiet.Load() {
+		return
+	}
+	funcName, file, line, err := getCallerInfo()
+	std.log(call{
+		funcName:  funcName,
+		file:      file,
+		line:      line,
+		callerErr: err,
+		level:     LevelInfo,
+		values:    v,
+	})
+}
+
+// QWarn is like QInfo, but the call is at LevelWarn.
+func QWarn(v ...interface{}) {
+	if std.quiet.Load() {
+		return
+	}
+	funcName, file, line, err := getCallerInfo()
+	std.log(call{
+		funcName:  funcName,
+		file:      file,
+		line:      line,
+		callerErr: err,
+		level:     LevelWarn,
+		values:    v,
+	})
+}
+
+// QError is like QInfo, but the call is at LevelError, e.g.
+//
+//	q.SetLevelFile(q.LevelError, "q.err")
+//	q.QError(err)
+//
+// writes err to $TMPDIR/q.err instead of the log file.
+func QError(v ...interface{}) {
+	if std.quiet.Load() {
+		return
+	}
+	funcName, file, line, err := getCallerInfo()
+	std.log(call{
+		funcName:  funcName,
+		file:      file,
+		line:      line,
+		callerErr: err,
+		level:     LevelError,
+		values:    v,
+	})
+}
+
+// QGroup is like Q, but it always starts a new log group, with the given name
+// in the header instead of the function name. The call after it starts a new
+// group too. Use it to mark important checkpoints in the log.
+func QGroup(name string, v ...interface{}) {
+	if std.quiet.Load() {
+		return
+	}
+	funcName, file, line, err := getCallerInfo()
+	std.log(call{
+		funcName:  funcName,
+		file:      file,
+		line:      line,
+		callerErr: err,
+		group:     name,
+		skip:      1,
+		values:    v,
+	})
+}
+
+// QAt is like Q, but the call is logged as if it happened at t, e.g. when
+// replaying or backfilling historical events. The header and the timestamps on
+// each line are computed from t instead of the current time, and a new log
+// group starts when t is 2s or more after the previous call to QAt(), or
+// before it.
+func QAt(t time.Time, v ...interface{}) {
+	if std.quiet.Load() {
+		return
+	}
+	funcName, file, line, err := getCallerInfo()
+	std.log(call{
+		funcName:  funcName,
+		file:      file,
+		line:      line,
+		callerErr: err,
+		at:        t,
+		skip:      1,
+		values:    v,
+	})
+}
+
+// QFrom is like Q, but the header shows the given file and line instead of the
+// caller's, e.g. to attribute an error to the stack frame it came from rather
+// than to the handler logging it. The function name is still the caller's.
+// Since the source at file:line isn't the call to QFrom, the values are
+// printed without names.
+func QFrom(file string, line int, v ...interface{}) {
+	if std.quiet.Load() {
+		return
+	}
+	funcName, _, _, err := getCallerInfo()
+	std.log(call{
+		funcName:  funcName,
+		file:      file,
+		line:      line,
+		callerErr: err,
+		names:     []string{},
+		values:    v,
+	})
+}
+
+// MarkHelper marks the function that calls it as a helper, like t.Helper() in
+// tests. When the helper, or a function it calls, calls q, the header shows
+// the line that called the helper rather than a line inside the helper. Use it
+// in test helpers so q output points at the test itself:
+//
+//	func checkUser(t *testing.T, u *User) {
+//		defer q.MarkHelper()()
+//		...
+//	}
+//
+// Note the () at the end: MarkHelper returns the function that unmarks the
+// helper, to defer, so q doesn't hold on to the mark after the helper returns.
+// Like t.Helper(), it only applies to the goroutine that calls it.
+func MarkHelper() func() {
+	pc, _, _, ok := runtime.Caller(1)
+	if !ok {
+		return func() {}
+	}
+	funcName := runtime.FuncForPC(pc).Name()
+	id := goroutineID()
+
+	helperMu.Lock()
+	defer helperMu.Unlock()
+
+	if helpers[id] == nil {
+		helpers[id] = make(map[string]int)
+	}
+	helpers[id][funcName]++
+
+	return func() {
+		helperMu.Lock()
+		defer helperMu.Unlock()
+
+		helpers[id][funcName]--
+		if helpers[id][funcName] <= 0 {
+			delete(helpers[id], funcName)
+		}
+		if len(helpers[id]) == 0 {
+			delete(helpers, id)
+		}
+	}
+}
+
+// Go calls f in a new goroutine, and remembers where Go was called. With
+// SetShowSpawnSite(), the headers of calls to q from that goroutine show where
+// it was started, e.g.
+//
+//	[14:00:36 worker.go:31 main.work (started at main.go:80 main.main)]
+//
+// The runtime doesn't keep track of where goroutines are started, so only the
+// ones started by Go get it. The site is only recorded for the goroutine Go
+// starts, not for the goroutines that one starts in turn.
+func Go(f func()) {
+	site := "unknown"
+	if pc, file, line, ok := runtime.Caller(1); ok {
+		site = fmt.Sprintf("%s:%d %s", shortFile(file), line, runtime.FuncForPC(pc).Name())
+	}
+
+	go func() {
+		id := goroutineID()
+		spawnMu.Lock()
+		spawns[id] = site
+		spawnMu.Unlock()
+
+		defer func() {
+			spawnMu.Lock()
+			delete(spawns, id)
+			spawnMu.Unlock()
+		}()
+
+		f()
+	}()
+}
+
+// QFields logs only the given fields of the struct v, instead of the whole
+// struct. Nested fields are given as dotted paths, e.g.
+//
+//	q.QFields(user, "ID", "Addr.City")
+//
+// Only exported fields can be printed. Pointers along the way are followed.
+// Fields that don't exist are printed as <no such field>.
+func QFields(v interface{}, fields ...string) {
+	if std.quiet.Load() {
+		return
+	}
+	funcName, file, line, err := getCallerInfo()
+
+	values := make([]interface{}, len(fields))
+	for i, f := range fields {
+		values[i] = fieldValue(reflect.ValueOf(v), f)
+	}
+
+	std.log(call{
+		funcName:  funcName,
+		file:      file,
+		line:      line,
+		callerErr: err,
+		fields:    fields,
+		values:    values,
+	})
+}
+
+// QReturn logs the values a function returns. Go has no way to get at a
+// function's return values, so they have to be named, and QReturn is given
+// pointers to them, each after its name:
+//
+//	func load(id int) (u *User, err error) {
+//		defer q.QReturn("u", &u, "err", &err)()
+//		...
+//	}
+//
+// Note the () at the end: QReturn returns the function to defer. The pointers
+// are dereferenced when the function exits, so the values logged are the ones
+// it returns, unless a function deferred earlier changes them afterwards. The
+// header points at the line with the defer.
+func QReturn(pairs ...interface{}) func() {
+	if std.quiet.Load() {
+		return func() {}
+	}
+	funcName, file, line, err := getCallerInfo()
+	return func() {
+		names, values := returnValues(pairs)
+		std.log(call{
+			funcName:  funcName,
+			file:      file,
+			line:      line,
+			callerErr: err,
+			names:     names,
+			values:    values,
+		})
+	}
+}
+
+// QEnv logs the environment variables whose names start with prefix, sorted by
+// name, one per line. An empty prefix logs all of them. The values of variables
+// with PASSWORD, SECRET, TOKEN, or KEY in their name are printed as <redacted>,
+// unless redaction is turned off with SetRedaction().
+func QEnv(prefix string) {
+	if std.quiet.Load() {
+		return
+	}
+	funcName, file, line, err := getCallerInfo()
+
+	names, values := envVars(os.Environ(), prefix)
+	note := ""
+	if len(names) == 0 {
+		note = fmt.Sprintf("no environment variables start with %q", prefix)
+	}
+
+	std.log(call{
+		funcName:  funcName,
+		file:      file,
+		line:      line,
+		callerErr: err,
+		note:      note,
+		names:     names,
+		lines:     true,
+		values:    values,
+	})
+}
+
+// Flush returns once every call to Q() and friends that started before it has
+// been written to the log file, so the file can be checked, e.g. in a test, or
+// copied before the program exits. It waits for the call in progress, if any,
+// and writes the calls held back by SetFlushEveryN(). Output captured by
+// CaptureStd() that is still in the pipe isn't waited for; call RestoreStd()
+// for that. Flush returns the error from writing the file, if any.
+func Flush() error {
+	return std.sync()
+}
+
+// Close writes any output q is holding on to, like Flush(), and closes the log
+// file if q keeps it open, which it only does with SetCompressed(). With
+// SetRunSummary(), it writes the summary first. A
+// compressed log isn't complete until it's closed, so call Close before the
+// program exits, e.g. with defer in main(). q can still be used after Close;
+// the next call opens the file again. A file set with SetFile() isn't closed.
+func Close() error {
+	return std.close()
+}
+
+// Qbuf is like Q, but instead of writing to the log file, it keeps the output
+// in memory until QbufDump() is called, e.g. in an error handler. Only the
+// output of the latest calls is kept; see SetQbufSize(). The values are
+// formatted when Qbuf is called, so later changes to them don't show up in the
+// dump. Each call is dumped with its own header.
+func Qbuf(v ...interface{}) {
+	if std.quiet.Load() {
+		return
+	}
+	funcName, file, line, err := getCallerInfo()
+	std.log(call{
+		funcName:  funcName,
+		file:      file,
+		line:      line,
+		callerErr: err,
+		buffered:  true,
+		values:    v,
+	})
+}
+
+// QbufDump writes the output kept by Qbuf() to the log file, oldest first, and
+// forgets it.
+func QbufDump() {
+	if std.quiet.Load() {
+		return
+	}
+	std.dumpRing()
+}
+
+// QTrace logs that execution reached the function that calls it, along with
+// the source of the call to that function, e.g.
+//
+//	called from server/main.go:42: h.handle(req, user)
+//
+// so there's no need to type out the names of the parameters. Put it at the
+// top of a function to trace calls to it. The source has to be available, as
+// for Q(); if it isn't, just the file and line are logged.
+func QTrace() {
+	if std.quiet.Load() {
+		return
+	}
+	funcName, file, line, callerFile, callerLine, err := getTraceInfo()
+
+	msg := "called from an unknown caller"
+	if callerFile != "" {
+		msg = fmt.Sprintf("called from %s:%d", shortFile(callerFile), callerLine)
+		if text, err := callText(callerFile, callerLine, funcName); err == nil {
+			msg += ": " + text
+		}
+	}
+
+	std.log(call{
+		funcName:  funcName,
+		file:      file,
+		line:      line,
+		callerErr: err,
+		names:     []string{""},
+		values:    []interface{}{msg},
+	})
+}
+
+// QInplace is like Q, but on sinks that are terminals, it overwrites the
+// previous line it wrote instead of adding a new one, e.g. to show the progress
+// of a loop without flooding the terminal:
+//
+//	q.AddSink(os.Stderr, q.FormatText)
+//	for i, job := range jobs {
+//		q.QInplace(i, len(jobs))
+//		...
+//	}
+//
+// The line is only overwritten by the next call to QInplace, and the output
+// of other calls starts on a new line below it. The header isn't shown on the
+// terminal, so the values should fit on one line. The log file, and sinks
+// that aren't terminals, get every call as usual.
+func QInplace(v ...interface{}) {
+	if std.quiet.Load() {
+		return
+	}
+	funcName, file, line, err := getCallerInfo()
+	std.log(call{
+		funcName:  funcName,
+		file:      file,
+		line:      line,
+		callerErr: err,
+		inplace:   true,
+		values:    v,
+	})
+}
+
+// QCtx is like Q, but it's given the context the call is made in, e.g. the
+// request's. With SetCorrelationKey(), the header shows the correlation ID
+// stored in the context, so every call can be attributed to its request
+// without passing the ID each time:
+//
+//	[14:00:36 server.go:42 main.handle id=4bf92f35]
+//
+// The context itself isn't logged.
+func QCtx(ctx context.Context, v ...interface{}) {
+	if std.quiet.Load() {
+		return
+	}
+	funcName, file, line, err := getCallerInfo()
+	std.log(call{
+		funcName:  funcName,
+		file:      file,
+		line:      line,
+		callerErr: err,
+		ctx:       ctx,
+		skip:      1,
+		values:    v,
+	})
+}
+
+// correlationID returns the value stored in ctx under key, or "-" if there
+// isn't one.
+func correlationID(ctx context.Context, key interface{}) string {
+	id := ctx.Value(key)
+	if id == nil {
+		return "-"
+	}
+	if s := fmt.Sprint(id); s != "" {
+		return s
+	}
+	return "-"
+}
+
+// QProgress logs the progress of a long loop or batch job, e.g.
+//
+//	42/100 (42%) elapsed 3s ETA ~4s
+//
+// where start is when the job started. The ETA assumes the rest of the job
+// goes as fast as the part that's done. It's unknown until something is done,
+// and left out once done reaches total. If total is 0, only the count and the
+// elapsed time are logged.
+func QProgress(done, total int, start time.Time) {
+	if std.quiet.Load() {
+		return
+	}
+	funcName, file, line, err := getCallerInfo()
+
+	std.mu.Lock()
+	elapsed := std.now().Sub(start)
+	std.mu.Unlock()
+
+	std.log(call{
+		funcName:  funcName,
+		file:      file,
+		line:      line,
+		callerErr: err,
+		names:     []string{""},
+		values:    []interface{}{progress(done, total, elapsed)},
+	})
+}
+
+// progress returns the line that QProgress() logs.
+func progress(done, total int, elapsed time.Duration) string {
+	s := fmt.Sprintf("%d/%d", done, total)
+	if total <= 0 {
+		return s + " elapsed " + roundDuration(elapsed).String()
+	}
+
+	s += fmt.Sprintf(" (%d%%) elapsed %v", done*100/total, roundDuration(elapsed))
+	switch {
+	case done <= 0:
+		s += " ETA unknown"
+	case done < total:
+		eta := time.Duration(float64(elapsed) * float64(total-done) / float64(done))
+		s += " ETA ~" + roundDuration(eta).String()
+	}
+	return s
+}
+
+// roundDuration rounds d to a precision that's easy to read: whole seconds if
+// it's longer than a minute, 10ms if it's longer than a second, and otherwise
+// whole ms.
+func roundDuration(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Minute:
+		return d.Round(time.Second)
+	case d >= time.Second:
+		return d.Round(10 * time.Millisecond)
+	}
+	return d.Round(time.Millisecond)
+}
+
+// QUnique is like Q, but it only logs values that it hasn't logged before, e.g.
+// to see each distinct error once. Values are compared by their pretty-printed
+// form, so two values that print the same are the same. The next time QUnique
+// logs something, it says how many calls it skipped. It remembers the last
+// 10,000 distinct values; older ones may be logged again.
+func QUnique(v ...interface{}) {
+	if std.quiet.Load() {
+		return
+	}
+	funcName, file, line, err := getCallerInfo()
+	std.log(call{
+		funcName:  funcName,
+		file:      file,
+		line:      line,
+		callerErr: err,
+		unique:    true,
+		values:    v,
+	})
 }
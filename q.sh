@@ -13,8 +13,14 @@ if [[ -z "$TMPDIR" ]]; then
 	fi
 fi
 
-if [[ ! -f "$logpath" ]]; then
-	touch $logpath
-fi
+# With q.SetCompressed(true), q writes to q.gz instead. Follow it if it's the
+# newer of the two.
+if [[ -f "$logpath.gz" && ! "$logpath" -nt "$logpath.gz" ]]; then
+	tail -c +1 -f "$logpath.gz" | gzip -dc
+else
+	if [[ ! -f "$logpath" ]]; then
+		touch $logpath
+	fi
 
-tail -100f $logpath
+	tail -100f $logpath
+fi
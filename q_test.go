@@ -6,9 +6,21 @@ package q
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -141,3 +153,1431 @@ func TestOutput(t *testing.T) {
 		}
 	}
 }
+
+// TestNote verifies that logger.print() prints the note given to Qn() in bold
+// above the values, and doesn't treat it as one of the values.
+func TestNote(t *testing.T) {
+	l := newLogger()
+	l.print(call{
+		funcName: "main.main",
+		file:     "testdata/sample2.go",
+		line:     9,
+		note:     "why we're here",
+		skip:     1,
+		values:   []interface{}{123, "hello world"},
+	})
+
+	lines := strings.Split(strings.TrimSpace(l.buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("\ngot %d lines, want 3 (header, note, values):\n%s", len(lines), l.buf.String())
+	}
+	if want := colorize("why we're here", bold); !strings.HasSuffix(lines[1], want) {
+		t.Fatalf("\ngot:  %q\nwant suffix: %q", lines[1], want)
+	}
+	want := fmt.Sprintf("%s=%s %s=%s",
+		colorize("a", bold), colorize("int(123)", cyan),
+		colorize("b", bold), colorize("hello world", cyan))
+	if !strings.HasSuffix(lines[2], want) {
+		t.Fatalf("\ngot:  %q\nwant suffix: %q", lines[2], want)
+	}
+}
+
+// TestEmptyCallMarker verifies that calls without values print the empty call
+// marker, or just the timestamp if it's "", and that calls with a note don't.
+func TestEmptyCallMarker(t *testing.T) {
+	testCases := []struct {
+		id     int
+		marker string
+		note   string
+		want   string
+	}{
+		{1, defaultEmptyMarker, "", "0.000s •\n"},
+		{2, "-- here --", "", "0.000s -- here --\n"},
+		{3, "", "", "0.000s \n"},
+		{4, defaultEmptyMarker, "why we're here", "0.000s why we're here\n0.000s \n"},
+	}
+
+	for _, tc := range testCases {
+		l := newLogger()
+		l.emptyMarker = tc.marker
+		l.print(call{
+			funcName: "main.main",
+			file:     "testdata/sample2.go",
+			line:     9,
+			note:     tc.note,
+		})
+
+		// Skip the blank line and the header.
+		got := strings.SplitN(stripColor(l.buf.String()), "\n", 3)[2]
+		if got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:  %q\nwant: %q", tc.id, got, tc.want)
+		}
+	}
+}
+
+// TestHeaderRefresh verifies that logger.header() reprints the header for the
+// same file and function once the header refresh interval has passed.
+func TestHeaderRefresh(t *testing.T) {
+	testCases := []struct {
+		refresh         time.Duration
+		sinceLastHeader time.Duration
+		wantEmptyString bool
+	}{
+		{refresh: 0, sinceLastHeader: time.Hour, wantEmptyString: true},
+		{refresh: time.Minute, sinceLastHeader: time.Second, wantEmptyString: true},
+		{refresh: time.Minute, sinceLastHeader: time.Hour, wantEmptyString: false},
+	}
+
+	for _, tc := range testCases {
+		l := &logger{
+			buf:           &bytes.Buffer{},
+			timer:         getTimer(false),
+			lastFile:      "foo.go",
+			lastFunc:      "foo.Bar",
+			lastHeader:    time.Now().Add(-tc.sinceLastHeader),
+			headerRefresh: tc.refresh,
+		}
+
+		h := l.header("foo.Bar", "foo.go", 123)
+		if gotEmptyString := h == ""; gotEmptyString != tc.wantEmptyString {
+			t.Fatalf("\nrefresh: %v, since last header: %v\ngot:  %q\nwant empty string: %v",
+				tc.refresh, tc.sinceLastHeader, h, tc.wantEmptyString)
+		}
+	}
+}
+
+// TestSessionID verifies that headers show the session ID, that generated IDs
+// stay the same, and that test mode replaces them with a fixed one.
+func TestSessionID(t *testing.T) {
+	auto := autoSessionID()
+	if len(auto) != 8 || auto != autoSessionID() {
+		t.Fatalf("got session IDs %q and %q, want the same 8 characters", auto, autoSessionID())
+	}
+
+	testCases := []struct {
+		id       int
+		sid      string
+		testMode bool
+		want     string
+	}{
+		{1, "", false, "[17:04:05 foo.go:123 foo.Bar]"},
+		{2, "run7", false, "[17:04:05 sid=run7 foo.go:123 foo.Bar]"},
+		{3, AutoSessionID, false, "[17:04:05 sid=" + auto + " foo.go:123 foo.Bar]"},
+		{4, AutoSessionID, true, "[00:00:00 sid=test foo.go:123 foo.Bar]"},
+	}
+
+	for _, tc := range testCases {
+		l := newLogger()
+		l.sessionID = tc.sid
+		l.clock = func() time.Time { return time.Date(2024, 3, 10, 17, 4, 5, 0, time.UTC) }
+		l.testMode = tc.testMode
+		l.testTime = testModeStart
+
+		if got := l.header("foo.Bar", "foo.go", 123); got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:  %s\nwant: %s", tc.id, got, tc.want)
+		}
+	}
+}
+
+// TestQuiet verifies that logger.log() doesn't write anything when logging has
+// been turned off with SetVerbose(false).
+func TestQuiet(t *testing.T) {
+	l := newLogger()
+	l.quiet.Store(true)
+	l.log(call{callerErr: errors.New("no caller"), values: []interface{}{1, 2, 3}})
+
+	if l.buf.Len() != 0 {
+		t.Fatalf("\ngot:  %q\nwant: %q", l.buf.String(), "")
+	}
+}
+
+// TestQuietExported verifies that with SetVerbose(false), the exported
+// functions return without taking q's lock.
+func TestQuietExported(t *testing.T) {
+	orig := std
+	std = newLogger()
+	defer func() { std = orig }()
+	SetVerbose(false)
+
+	std.mu.Lock()
+	defer std.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Q(1)
+		Qn("note", 1)
+		QError(1)
+		QFields(struct{ X int }{}, "X")
+		QEnv("")
+		QProgress(1, 2, time.Now())
+		QTrace()
+		QSizeof(1)
+		QSliceDiff([]int{1})
+		QOpt(nil, 1)
+		QReturn("x", new(int))()
+		QLeakCheck()()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("a call blocked on q's lock while logging was off")
+	}
+}
+
+// TestWriteBOM verifies that logger.flush() writes the UTF-8 BOM once, at the
+// start of the log file, and again after the file has been truncated.
+func TestWriteBOM(t *testing.T) {
+	dir, cleanup := setTempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "q")
+
+	l := newLogger()
+	l.writeBOM = true
+
+	write := func(s string) {
+		l.buf.WriteString(s)
+		l.flush()
+	}
+
+	write("one\n")
+	write("two\n")
+	assertFileContents(t, path, utf8BOM+"one\ntwo\n")
+
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatalf("failed to truncate %q: %v", path, err)
+	}
+	write("three\n")
+	assertFileContents(t, path, utf8BOM+"three\n")
+}
+
+// TestFileHeader verifies that the file header is written at the top of new
+// and truncated log files, after the BOM.
+func TestFileHeader(t *testing.T) {
+	dir, cleanup := setTempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "q")
+
+	l := newLogger()
+	l.fileHeader = "== myserver =="
+
+	write := func(s string) {
+		l.buf.WriteString(s)
+		l.flush()
+	}
+
+	write("one\n")
+	write("two\n")
+	assertFileContents(t, path, "== myserver ==\none\ntwo\n")
+
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatalf("failed to truncate %q: %v", path, err)
+	}
+	l.writeBOM = true
+	write("three\n")
+	assertFileContents(t, path, utf8BOM+"== myserver ==\nthree\n")
+}
+
+// TestShowBuildInfo verifies that the build info is written once by a process
+// that finds the log file already has content, and again after the file header
+// whenever the file is truncated.
+func TestShowBuildInfo(t *testing.T) {
+	dir, cleanup := setTempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "q")
+
+	if err := ioutil.WriteFile(path, []byte("old\n"), 0666); err != nil {
+		t.Fatalf("failed to write %q: %v", path, err)
+	}
+
+	l := newLogger()
+	l.fileHeader = "== myserver =="
+	l.buildInfo = true
+	build := formatBuildInfo(debug.ReadBuildInfo()) + "\n"
+
+	write := func(s string) {
+		l.buf.WriteString(s)
+		l.flush()
+	}
+
+	write("one\n")
+	write("two\n")
+	assertFileContents(t, path, "old\n"+build+"one\ntwo\n")
+
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatalf("failed to truncate %q: %v", path, err)
+	}
+	write("three\n")
+	assertFileContents(t, path, "== myserver ==\n"+build+"three\n")
+}
+
+// TestFormatBuildInfo verifies that formatBuildInfo() describes the main
+// module and its VCS stamp, and copes with missing build info.
+func TestFormatBuildInfo(t *testing.T) {
+	tests := []struct {
+		bi   *debug.BuildInfo
+		ok   bool
+		want string
+	}{
+		{nil, false, "build: no build info"},
+		{&debug.BuildInfo{}, true, "build: unknown (devel)"},
+		{
+			bi: &debug.BuildInfo{
+				Path: "github.com/me/server/cmd/server",
+				Main: debug.Module{Path: "github.com/me/server", Version: "v1.4.0"},
+			},
+			ok:   true,
+			want: "build: github.com/me/server v1.4.0",
+		},
+		{
+			bi: &debug.BuildInfo{
+				Path: "github.com/me/server",
+				Settings: []debug.BuildSetting{
+					{Key: "vcs", Value: "git"},
+					{Key: "vcs.revision", Value: "3f2a9c1e"},
+					{Key: "vcs.modified", Value: "true"},
+				},
+			},
+			ok:   true,
+			want: "build: github.com/me/server (devel), revision 3f2a9c1e, dirty",
+		},
+		{
+			bi: &debug.BuildInfo{
+				Main: debug.Module{Path: "github.com/me/server", Version: "(devel)"},
+				Settings: []debug.BuildSetting{
+					{Key: "vcs.revision", Value: "3f2a9c1e"},
+					{Key: "vcs.modified", Value: "false"},
+				},
+			},
+			ok:   true,
+			want: "build: github.com/me/server (devel), revision 3f2a9c1e",
+		},
+	}
+
+	for i, tc := range tests {
+		got := formatBuildInfo(tc.bi, tc.ok)
+		if got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:  %s\nwant: %s", i, got, tc.want)
+		}
+	}
+}
+
+// TestFlushConcurrent verifies that sync() leaves the log file consistent while
+// other goroutines are logging, and that every call is written.
+func TestFlushConcurrent(t *testing.T) {
+	dir, cleanup := setTempDir(t)
+	defer cleanup()
+
+	const goroutines, calls = 8, 50
+	l := newLogger()
+
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < calls; j++ {
+				l.log(call{
+					funcName: "main.main",
+					file:     "testdata/sample2.go",
+					line:     9,
+					skip:     1,
+					values:   []interface{}{123, "hello world"},
+				})
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	path := filepath.Join(dir, "q")
+	for flushing := true; flushing; {
+		select {
+		case <-done:
+			flushing = false
+		default:
+		}
+
+		if err := l.sync(); err != nil {
+			t.Fatalf("sync() failed: %v", err)
+		}
+		// Keep the other goroutines from writing while the file is read.
+		l.mu.Lock()
+		b, err := ioutil.ReadFile(path)
+		l.mu.Unlock()
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatalf("failed to read log file: %v", err)
+		}
+		if len(b) > 0 && !bytes.HasSuffix(b, []byte("\n")) {
+			t.Fatalf("log file ends in the middle of a line after sync():\n%s", b)
+		}
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read log file: %v", err)
+	}
+	if n := strings.Count(string(b), "hello world"); n != goroutines*calls {
+		t.Fatalf("\ngot %d calls in the log file, want %d", n, goroutines*calls)
+	}
+}
+
+// TestQbuf verifies that buffered calls are kept in memory, up to the ring
+// size, until the ring is dumped.
+func TestQbuf(t *testing.T) {
+	dir, cleanup := setTempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "q")
+
+	l := newLogger()
+	l.ringSize = 2
+	for i := 1; i <= 3; i++ {
+		l.log(call{
+			funcName: "main.main",
+			file:     "testdata/sample2.go",
+			line:     9,
+			skip:     1,
+			buffered: true,
+			values:   []interface{}{i, "hello world"},
+		})
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("buffered calls were written to the log file before the dump")
+	}
+
+	l.dumpRing()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read log file: %v", err)
+	}
+	out := stripColor(string(b))
+	if n := strings.Count(out, "main.main]"); n != 2 {
+		t.Fatalf("\ngot %d headers, want 2:\n%s", n, out)
+	}
+	if strings.Contains(out, "a=int(1)") || !strings.Contains(out, "a=int(2)") || !strings.Contains(out, "a=int(3)") {
+		t.Fatalf("\ngot:  %s\nwant the last 2 calls", out)
+	}
+
+	if len(l.ring) != 0 {
+		t.Fatalf("ring has %d calls after the dump, want 0", len(l.ring))
+	}
+}
+
+// TestSequence verifies that each logged call takes the next sequence number,
+// and that it's printed when showSeq is set.
+func TestSequence(t *testing.T) {
+	dir, cleanup := setTempDir(t)
+	defer cleanup()
+
+	l1, l2 := newLogger(), newLogger()
+	l1.showSeq = true
+	c := call{
+		funcName: "main.main",
+		file:     "testdata/sample2.go",
+		line:     9,
+		skip:     1,
+		values:   []interface{}{123, "hello world"},
+	}
+
+	first := Sequence() + 1
+	l1.log(c)
+	l2.log(c)
+	l1.log(c)
+	if got, want := Sequence(), first+2; got != want {
+		t.Fatalf("\ngot:  Sequence() = %d\nwant: %d", got, want)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "q"))
+	if err != nil {
+		t.Fatalf("failed to read log file: %v", err)
+	}
+	out := stripColor(string(b))
+	for _, want := range []string{fmt.Sprintf(" #%d a=", first), fmt.Sprintf(" #%d a=", first+2)} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("\ngot:  %s\nmissing: %q", out, want)
+		}
+	}
+	if strings.Contains(out, fmt.Sprintf("#%d", first+1)) {
+		t.Fatalf("\ngot:  %s\nwant no sequence number from the logger with showSeq off", out)
+	}
+}
+
+// TestFlushEveryN verifies that calls are only written to disk every
+// flushEvery calls, or when the logger is synced.
+func TestFlushEveryN(t *testing.T) {
+	dir, cleanup := setTempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "q")
+
+	l := newLogger()
+	l.flushEvery = 3
+	c := call{
+		funcName: "main.main",
+		file:     "testdata/sample2.go",
+		line:     9,
+		skip:     1,
+		values:   []interface{}{123, "hello world"},
+	}
+	count := func() int {
+		b, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatalf("failed to read log file: %v", err)
+		}
+		return strings.Count(string(b), "hello world")
+	}
+
+	for i, want := range []int{0, 0, 3, 3} {
+		l.log(c)
+		if got := count(); got != want {
+			t.Fatalf("\nafter call %d\ngot:  %d calls in the log file\nwant: %d", i+1, got, want)
+		}
+	}
+
+	if err := l.sync(); err != nil {
+		t.Fatalf("sync() failed: %v", err)
+	}
+	if got := count(); got != 4 {
+		t.Fatalf("\nafter sync()\ngot:  %d calls in the log file\nwant: 4", got)
+	}
+}
+
+// assertFileContents fails the test if the file at path doesn't contain want.
+func assertFileContents(t *testing.T, path, want string) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %q: %v", path, err)
+	}
+	if got := string(b); got != want {
+		t.Fatalf("\n%s\ngot:  %q\nwant: %q", path, got, want)
+	}
+}
+
+// TestSetFile verifies that flush() writes to a borrowed file, and doesn't
+// close it.
+func TestSetFile(t *testing.T) {
+	dir, cleanup := setTempDir(t)
+	defer cleanup()
+
+	f, err := ioutil.TempFile(dir, "borrowed")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer f.Close()
+
+	l := newLogger()
+	l.writeBOM = true
+	l.file = f
+
+	l.buf.WriteString("one\n")
+	if err := l.flush(); err != nil {
+		t.Fatalf("flush() failed: %v", err)
+	}
+	if _, err := f.WriteString("mine\n"); err != nil {
+		t.Fatalf("borrowed file was closed: %v", err)
+	}
+	assertFileContents(t, f.Name(), utf8BOM+"one\nmine\n")
+
+	if _, err := os.Stat(filepath.Join(dir, "q")); !os.IsNotExist(err) {
+		t.Fatalf("$TMPDIR/q was written to, want only the borrowed file")
+	}
+}
+
+// TestPerUserFile verifies that the log file name includes the user when
+// perUser is set, or when $TMPDIR/q can't be opened.
+func TestPerUserFile(t *testing.T) {
+	dir, cleanup := setTempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "q")
+	userPath := path + "-" + userName()
+
+	l := newLogger()
+	l.perUser = true
+	l.buf.WriteString("one\n")
+	if err := l.flush(); err != nil {
+		t.Fatalf("flush() failed: %v", err)
+	}
+	assertFileContents(t, userPath, "one\n")
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("%s was written to, want only %s", path, userPath)
+	}
+
+	// Make $TMPDIR/q unwritable, as if it belonged to another user.
+	if err := ioutil.WriteFile(path, nil, 0400); err != nil {
+		t.Fatalf("failed to create %q: %v", path, err)
+	}
+	if f, err := os.OpenFile(path, os.O_WRONLY, 0); err == nil {
+		f.Close()
+		t.Skip("can't test the fallback, since read-only files are writable, e.g. by root")
+	}
+
+	l.perUser = false
+	l.buf.WriteString("two\n")
+	if err := l.flush(); err != nil {
+		t.Fatalf("flush() failed: %v", err)
+	}
+	assertFileContents(t, userPath, "one\ntwo\n")
+}
+
+// TestSetPerUserFileFlushes verifies that output buffered before
+// SetPerUserFile() is written to the file it was meant for.
+func TestSetPerUserFileFlushes(t *testing.T) {
+	dir, cleanup := setTempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "q")
+	userPath := path + "-" + userName()
+
+	orig := std
+	std = newLogger()
+	defer func() { std = orig }()
+
+	std.buf.WriteString("one\n")
+	SetPerUserFile(true)
+	assertFileContents(t, path, "one\n")
+
+	std.buf.WriteString("two\n")
+	SetPerUserFile(false)
+	assertFileContents(t, userPath, "two\n")
+}
+
+// TestCompressed verifies that the compressed log file can be read after each
+// flush, and is complete once it's closed.
+func TestCompressed(t *testing.T) {
+	dir, cleanup := setTempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "q.gz")
+
+	l := newLogger()
+	l.compressed = true
+	l.fileHeader = "# q log"
+
+	l.buf.WriteString("one\n")
+	if err := l.flush(); err != nil {
+		t.Fatalf("flush() failed: %v", err)
+	}
+	if got, err := readGzip(path); err != io.ErrUnexpectedEOF || got != "# q log\none\n" {
+		t.Fatalf("\nbefore close\ngot:  %q, %v\nwant: %q, %v", got, err, "# q log\none\n", io.ErrUnexpectedEOF)
+	}
+
+	l.buf.WriteString("two\n")
+	if err := l.close(); err != nil {
+		t.Fatalf("close() failed: %v", err)
+	}
+	if got, err := readGzip(path); err != nil || got != "# q log\none\ntwo\n" {
+		t.Fatalf("\nafter close\ngot:  %q, %v\nwant: %q", got, err, "# q log\none\ntwo\n")
+	}
+
+	// After Close(), a new stream is appended to the file.
+	l.buf.WriteString("three\n")
+	if err := l.close(); err != nil {
+		t.Fatalf("close() failed: %v", err)
+	}
+	if got, err := readGzip(path); err != nil || got != "# q log\none\ntwo\nthree\n" {
+		t.Fatalf("\nsecond stream\ngot:  %q, %v\nwant: %q", got, err, "# q log\none\ntwo\nthree\n")
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "q")); !os.IsNotExist(err) {
+		t.Fatalf("$TMPDIR/q was written to, want only $TMPDIR/q.gz")
+	}
+}
+
+// readGzip returns the decompressed contents of the given file, and the error
+// from decompressing it, if any.
+func readGzip(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return "", err
+	}
+	b, err := ioutil.ReadAll(zr)
+	return string(b), err
+}
+
+// flakyWriter fails the first failures calls to Write, after writing at most 3
+// bytes.
+type flakyWriter struct {
+	bytes.Buffer
+	failures int
+}
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	if w.failures == 0 {
+		return w.Buffer.Write(p)
+	}
+	w.failures--
+	if len(p) > 3 {
+		p = p[:3]
+	}
+	n, _ := w.Buffer.Write(p)
+	return n, errors.New("resource temporarily unavailable")
+}
+
+// TestFlushRetries verifies that writeRetry() retries failed writes without
+// writing anything twice, and gives up after the configured number of retries.
+func TestFlushRetries(t *testing.T) {
+	testCases := []struct {
+		id       int
+		retries  int
+		failures int
+		want     string
+		wantErr  bool
+	}{
+		{1, 0, 0, "one\ntwo\n", false},
+		{2, 0, 1, "one", true},
+		{3, 2, 2, "one\ntwo\n", false},
+		{4, 1, 3, "one\ntw", true},
+	}
+
+	for _, tc := range testCases {
+		l := newLogger()
+		l.flushRetries = tc.retries
+		l.flushBackoff = time.Millisecond
+
+		w := &flakyWriter{failures: tc.failures}
+		err := l.writeRetry(w, []byte("one\ntwo\n"))
+		if got := w.String(); got != tc.want || (err != nil) != tc.wantErr {
+			t.Fatalf("\nTEST %d\ngot:  %q, %v\nwant: %q, error: %t", tc.id, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+// TestSnapshotDir verifies that each call is written to its own file, named
+// after its sequence number, with its own header.
+func TestSnapshotDir(t *testing.T) {
+	dir, cleanup := setTempDir(t)
+	defer cleanup()
+	snapshots := filepath.Join(dir, "snapshots")
+
+	l := newLogger()
+	l.snapshotDir = snapshots
+	l.format = FormatPlain
+	l.clock = func() time.Time { return time.Date(2024, 3, 10, 17, 4, 5, 0, time.UTC) }
+	c := call{
+		funcName: "main.main",
+		file:     "testdata/sample2.go",
+		line:     9,
+		skip:     1,
+	}
+	for i := 1; i <= 2; i++ {
+		c.values = []interface{}{i, "hello world"}
+		l.log(c)
+
+		path := filepath.Join(snapshots, fmt.Sprintf("%04d.txt", Sequence()))
+		want := fmt.Sprintf("-- [17:04:05 testdata/sample2.go:9 main.main] %s\n0.000s a=int(%d) b=hello world\n",
+			strings.Repeat("-", 34), i)
+		assertFileContents(t, path, want)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "q")); !os.IsNotExist(err) {
+		t.Fatalf("$TMPDIR/q was written to, want only the snapshots")
+	}
+}
+
+// TestLevelFile verifies that calls at a level with its own file are written
+// there instead of the log file, each with a header, and that the next call
+// written to the log file gets a header too.
+func TestLevelFile(t *testing.T) {
+	dir, cleanup := setTempDir(t)
+	defer cleanup()
+
+	l := newLogger()
+	l.levelFiles = map[Level]string{LevelError: "q.err"}
+	l.format = FormatPlain
+	l.clock = func() time.Time { return time.Date(2024, 3, 10, 17, 4, 5, 0, time.UTC) }
+	c := call{
+		funcName: "main.main",
+		file:     "testdata/sample2.go",
+		line:     9,
+		skip:     1,
+	}
+	for i, lv := range []Level{LevelDebug, LevelError, LevelDebug} {
+		c.level = lv
+		c.values = []interface{}{i, "hello world"}
+		l.log(c)
+	}
+	l.flush()
+
+	header := "-- [17:04:05 testdata/sample2.go:9 main.main] " + strings.Repeat("-", 34) + "\n"
+	assertFileContents(t, filepath.Join(dir, "q"),
+		"\n"+header+"0.000s a=int(0) b=hello world\n"+
+			"\n"+header+"0.000s a=int(2) b=hello world\n")
+	assertFileContents(t, filepath.Join(dir, "q.err"),
+		"\n"+header+"0.000s a=int(1) b=hello world\n")
+}
+
+// TestLevelFunctions verifies that QInfo(), QWarn() and QError() log at their
+// levels, so SetLevelFile() routes them, and that Q() logs at LevelDebug.
+func TestLevelFunctions(t *testing.T) {
+	dir, cleanup := setTempDir(t)
+	defer cleanup()
+
+	orig := std
+	std = newLogger()
+	defer func() { std = orig }()
+	std.format = FormatPlain
+	SetLevelFile(LevelWarn, "q.warn")
+	SetLevelFile(LevelError, "q.err")
+
+	var levels []Level
+	SetRecordSink(func(r Record) { levels = append(levels, r.Level) })
+
+	Q("debug")
+	QInfo("info")
+	QWarn("warn")
+	QError("error")
+	std.flush()
+
+	want := []Level{LevelDebug, LevelInfo, LevelWarn, LevelError}
+	if !reflect.DeepEqual(levels, want) {
+		t.Fatalf("\ngot:  %v\nwant: %v", levels, want)
+	}
+
+	testCases := []struct {
+		id   int
+		file string
+		want []string
+		not  []string
+	}{
+		{1, "q", []string{"debug", "info"}, []string{"warn", "error"}},
+		{2, "q.warn", []string{"warn"}, []string{"debug", "info", "error"}},
+		{3, "q.err", []string{"error"}, []string{"debug", "info", "warn"}},
+	}
+	for _, tc := range testCases {
+		b, err := ioutil.ReadFile(filepath.Join(dir, tc.file))
+		if err != nil {
+			t.Fatalf("\nTEST %d\nfailed to read %q: %v", tc.id, tc.file, err)
+		}
+		for _, s := range tc.want {
+			if !strings.Contains(string(b), s) {
+				t.Fatalf("\nTEST %d\ngot:  %s\nwant: %q in it", tc.id, b, s)
+			}
+		}
+		for _, s := range tc.not {
+			if strings.Contains(string(b), s) {
+				t.Fatalf("\nTEST %d\ngot:  %s\nwant: no %q in it", tc.id, b, s)
+			}
+		}
+	}
+}
+
+// TestCurrentGroup verifies that calls are grouped under the label set with
+// SetCurrentGroup() wherever they're from, that changing the label starts a
+// new group, and that clearing it goes back to grouping by file and function.
+func TestCurrentGroup(t *testing.T) {
+	dir, cleanup := setTempDir(t)
+	defer cleanup()
+
+	orig := std
+	std = newLogger()
+	defer func() { std = orig }()
+	std.format = FormatPlain
+	std.clock = func() time.Time { return time.Date(2024, 3, 10, 17, 4, 5, 0, time.UTC) }
+
+	sample1 := call{funcName: "main.main", file: "testdata/sample1.go", line: 14}
+	sample2 := call{funcName: "main.main", file: "testdata/sample2.go", line: 9, skip: 1}
+	log := func(c call, v ...interface{}) {
+		c.values = v
+		std.log(c)
+	}
+
+	SetCurrentGroup("phase-1")
+	log(sample1, 1, 2, 3, 4, 5, 6, 7)
+	log(sample2, 8, 9)
+	SetCurrentGroup("phase-2")
+	log(sample2, 10, 11)
+	SetCurrentGroup("")
+	log(sample2, 12, 13)
+
+	header := func(s string) string {
+		h := "-- [17:04:05 " + s + "] "
+		return "\n" + h + strings.Repeat("-", maxLineWidth-len(h)) + "\n"
+	}
+	want := header("phase-1") +
+		"0.000s a=int(1) b=int(2) c=int(3) d=int(4) e=int(5) f=int(6) g=int(7)\n" +
+		"0.000s a=int(8) b=int(9)\n" +
+		header("phase-2") +
+		"0.000s a=int(10) b=int(11)\n" +
+		header("testdata/sample2.go:9 main.main") +
+		"0.000s a=int(12) b=int(13)\n"
+	assertFileContents(t, filepath.Join(dir, "q"), want)
+}
+
+// TestTestMode verifies that the output in test mode is the same on every run.
+func TestTestMode(t *testing.T) {
+	dir, cleanup := setTempDir(t)
+	defer cleanup()
+
+	for run := 0; run < 2; run++ {
+		l := newLogger()
+		l.testMode = true
+		l.testTime = testModeStart
+		l.showSeq = true
+		l.clock = time.Now
+
+		c := call{
+			funcName: "main.main",
+			file:     "testdata/sample2.go",
+			line:     9,
+			skip:     1,
+			values:   []interface{}{123, "hello world"},
+		}
+		l.log(c)
+		time.Sleep(10 * time.Millisecond)
+		l.log(c)
+
+		want := "\n-- [00:00:00 testdata/sample2.go:9 main.main] " + strings.Repeat("-", 34) + "\n" +
+			"0.000s a=int(123) b=hello world\n" +
+			"0.001s a=int(123) b=hello world\n"
+		assertFileContents(t, filepath.Join(dir, "q"), want)
+		os.Remove(filepath.Join(dir, "q"))
+	}
+}
+
+// TestRunSummary verifies that close() writes a summary of the run, counting
+// the output that hasn't been flushed yet.
+func TestRunSummary(t *testing.T) {
+	dir, cleanup := setTempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "q")
+
+	l := newLogger()
+	l.runSummary = true
+	l.flushEvery = 2
+	c := call{
+		funcName: "main.main",
+		file:     "testdata/sample2.go",
+		line:     9,
+		skip:     1,
+		values:   []interface{}{123, "hello world"},
+	}
+	for i := 0; i < 3; i++ {
+		l.log(c)
+	}
+	c.values = []interface{}{math.NaN(), "hello world"}
+	c.group = "checkpoint"
+	l.log(c)
+	if err := l.close(); err != nil {
+		t.Fatalf("close() failed: %v", err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %q: %v", path, err)
+	}
+	out := stripColor(string(b))
+	i := strings.LastIndex(out, "\nq run summary: ")
+	if i < 0 {
+		t.Fatalf("no run summary in:\n%s", out)
+	}
+	want := fmt.Sprintf("q run summary: 4 calls in 2 groups, %d B written, ran for ", len(b[:strings.LastIndex(string(b), "\n\x1b")]))
+	if got := out[i+1:]; !strings.HasPrefix(got, want) || !strings.HasSuffix(got, ", 1 NaN/Inf values\n") {
+		t.Fatalf("\ngot:  %s\nwant: %s..., 1 NaN/Inf values", got, want)
+	}
+}
+
+// TestRunSummaryTestMode verifies that in test mode, the run summary says the
+// run took 1ms per call, as measured by the test mode clock.
+func TestRunSummaryTestMode(t *testing.T) {
+	dir, cleanup := setTempDir(t)
+	defer cleanup()
+
+	orig := std
+	std = newLogger()
+	defer func() { std = orig }()
+	std.runSummary = true
+	SetTestMode(true)
+
+	c := call{
+		funcName: "main.main",
+		file:     "testdata/sample2.go",
+		line:     9,
+		skip:     1,
+		values:   []interface{}{123, "hello world"},
+	}
+	for i := 0; i < 3; i++ {
+		std.log(c)
+	}
+	if err := Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "q"))
+	if err != nil {
+		t.Fatalf("failed to read the log file: %v", err)
+	}
+	want := ", ran for 3ms\n"
+	if !strings.HasSuffix(string(b), want) {
+		t.Fatalf("\ngot:  %s\nwant: ...%s", b, want)
+	}
+}
+
+// TestClock verifies that the times in the log come from the logger's clock.
+func TestClock(t *testing.T) {
+	now := time.Date(2024, 3, 10, 17, 4, 5, 0, time.UTC)
+	l := newLogger()
+	l.clock = func() time.Time { return now }
+
+	c := call{
+		funcName: "main.main",
+		file:     "testdata/sample2.go",
+		line:     9,
+		skip:     1,
+		values:   []interface{}{123, "hello world"},
+	}
+	l.print(c)
+	now = now.Add(1500 * time.Millisecond)
+	l.print(c)
+
+	got := stripColor(l.buf.String())
+	want := "\n[17:04:05 testdata/sample2.go:9 main.main]\n" +
+		"0.000s a=int(123) b=hello world\n" +
+		"1.500s a=int(123) b=hello world\n"
+	if got != want {
+		t.Fatalf("\ngot:  %q\nwant: %q", got, want)
+	}
+}
+
+// TestClockGroups verifies that with a clock, new log groups start when the
+// clock says 2s have passed, whatever the real time.
+func TestClockGroups(t *testing.T) {
+	now := time.Date(2024, 3, 10, 17, 4, 5, 0, time.UTC)
+	l := newLogger()
+	l.clock = func() time.Time { return now }
+
+	c := call{
+		funcName: "main.main",
+		file:     "testdata/sample2.go",
+		line:     9,
+		skip:     1,
+		values:   []interface{}{123, "hello world"},
+	}
+	l.print(c)
+	now = now.Add(time.Second)
+	l.timer.Stop() // as if 2s of real time had passed
+	l.print(c)
+	now = now.Add(3 * time.Second)
+	l.print(c)
+
+	got := stripColor(l.buf.String())
+	want := "\n[17:04:05 testdata/sample2.go:9 main.main]\n" +
+		"0.000s a=int(123) b=hello world\n" +
+		"1.000s a=int(123) b=hello world\n" +
+		"\n[17:04:09 testdata/sample2.go:9 main.main]\n" +
+		"0.000s a=int(123) b=hello world\n"
+	if got != want {
+		t.Fatalf("\ngot:  %q\nwant: %q", got, want)
+	}
+}
+
+// TestAt verifies that calls to QAt() are stamped, and grouped, by the times
+// they're given rather than by the clock.
+func TestAt(t *testing.T) {
+	l := newLogger()
+	l.clock = func() time.Time { return time.Date(2024, 3, 10, 17, 4, 5, 0, time.UTC) }
+
+	at := time.Date(2019, 6, 1, 8, 30, 0, 0, time.UTC)
+	c := call{
+		funcName: "main.main",
+		file:     "testdata/sample2.go",
+		line:     9,
+		skip:     1,
+		values:   []interface{}{123, "hello world"},
+	}
+	for _, d := range []time.Duration{0, 250 * time.Millisecond, 5 * time.Second} {
+		c.at = at.Add(d)
+		l.print(c)
+	}
+	c.at = time.Time{}
+	l.print(c)
+
+	got := stripColor(l.buf.String())
+	want := "\n[08:30:00 testdata/sample2.go:9 main.main]\n" +
+		"0.000s a=int(123) b=hello world\n" +
+		"0.250s a=int(123) b=hello world\n" +
+		"\n[08:30:05 testdata/sample2.go:9 main.main]\n" +
+		"0.000s a=int(123) b=hello world\n" +
+		"\n[17:04:05 testdata/sample2.go:9 main.main]\n" +
+		"0.000s a=int(123) b=hello world\n"
+	if got != want {
+		t.Fatalf("\ngot:  %q\nwant: %q", got, want)
+	}
+}
+
+// TestTrimPrefix verifies that headers show file paths relative to the trim
+// prefix, and fall back to <directory>/<file> for files outside of it.
+func TestTrimPrefix(t *testing.T) {
+	testCases := []struct {
+		id     int
+		prefix string
+		file   string
+		want   string
+	}{
+		{1, "", "/src/mod/internal/db/conn.go", "db/conn.go"},
+		{2, "/src/mod", "/src/mod/internal/db/conn.go", "internal/db/conn.go"},
+		{3, "/src/mod/", "/src/mod/main.go", "main.go"},
+		{4, "/src/mod", "/src/module/internal/db/conn.go", "db/conn.go"},
+		{5, "example.com/mod", "example.com/mod/internal/db/conn.go", "internal/db/conn.go"},
+	}
+
+	for _, tc := range testCases {
+		l := newLogger()
+		l.trimPrefix = tc.prefix
+		got := l.header("main.main", tc.file, 7)
+		if want := tc.want + ":7 main.main]"; !strings.HasSuffix(got, want) {
+			t.Fatalf("\nTEST %d\ngot:  %s\nwant: %s", tc.id, got, want)
+		}
+	}
+}
+
+// TestAdaptiveGrouping verifies that, with adaptive grouping, a new group
+// starts when the gap before a call is much longer than the site's average.
+func TestAdaptiveGrouping(t *testing.T) {
+	start := time.Date(2024, 3, 10, 17, 4, 5, 0, time.UTC)
+	now := start
+	l := newLogger()
+	l.adaptive = true
+	l.clock = func() time.Time { return now }
+
+	testCases := []struct {
+		id     int
+		at     time.Duration
+		header bool
+	}{
+		{1, 0, true}, // first call
+		{2, 100 * time.Millisecond, false},
+		{3, 200 * time.Millisecond, false},
+		{4, 300 * time.Millisecond, false},
+		{5, 350 * time.Millisecond, false},
+		{6, 1200 * time.Millisecond, true}, // pause
+		{7, 1300 * time.Millisecond, false},
+		{8, 1500 * time.Millisecond, false}, // longer than usual, but not by much
+		{9, 1800 * time.Millisecond, false},
+		{10, 1810 * time.Millisecond, false},
+		{11, 1830 * time.Millisecond, false},
+	}
+
+	for _, tc := range testCases {
+		now = start.Add(tc.at)
+		got := l.header("main.main", "testdata/sample1.go", 14) != ""
+		if got != tc.header {
+			t.Fatalf("\nTEST %d\ngot header:  %t\nwant header: %t", tc.id, got, tc.header)
+		}
+	}
+}
+
+// TestFrom verifies that QFrom() calls are attributed to the given file and
+// line, and printed without names.
+func TestFrom(t *testing.T) {
+	l := newLogger()
+	l.print(call{
+		funcName: "main.handle",
+		file:     "testdata/sample2.go",
+		line:     9,
+		names:    []string{},
+		values:   []interface{}{123, "connection reset"},
+	})
+
+	got := stripColor(l.buf.String())
+	if want := "testdata/sample2.go:9 main.handle]\n0.000s int(123) connection reset\n"; !strings.HasSuffix(got, want) {
+		t.Fatalf("\ngot:  %q\nwant: %q", got, want)
+	}
+}
+
+// TestProgress verifies that progress() extrapolates the ETA, and copes with
+// jobs that have nothing done, no total, or more done than the total.
+func TestProgress(t *testing.T) {
+	testCases := []struct {
+		id          int
+		done, total int
+		elapsed     time.Duration
+		want        string
+	}{
+		{1, 42, 100, 3 * time.Second, "42/100 (42%) elapsed 3s ETA ~4.14s"},
+		{2, 0, 100, 1500 * time.Microsecond, "0/100 (0%) elapsed 2ms ETA unknown"},
+		{3, 100, 100, 90 * time.Second, "100/100 (100%) elapsed 1m30s"},
+		{4, 120, 100, time.Second, "120/100 (120%) elapsed 1s"},
+		{5, 7, 0, time.Second, "7/0 elapsed 1s"},
+		{6, 1, 1000, time.Minute, "1/1000 (0%) elapsed 1m0s ETA ~16h39m0s"},
+	}
+
+	for _, tc := range testCases {
+		if got := progress(tc.done, tc.total, tc.elapsed); got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:  %s\nwant: %s", tc.id, got, tc.want)
+		}
+	}
+}
+
+type requestIDKey struct{}
+
+// TestCorrelationKey verifies that the headers of calls made with a context
+// show the correlation ID from the context, or - if there isn't one.
+func TestCorrelationKey(t *testing.T) {
+	l := newLogger()
+	l.correlationKey = requestIDKey{}
+
+	c := call{
+		funcName: "main.handle",
+		file:     "testdata/sample1.go",
+		line:     14,
+		names:    []string{"a"},
+		values:   []interface{}{1},
+	}
+	l.print(c)
+	c.ctx = context.WithValue(context.Background(), requestIDKey{}, "4bf92f35")
+	l.print(c)
+	c.ctx = context.Background()
+	l.print(c)
+
+	var headers []string
+	for _, line := range strings.Split(stripColor(l.buf.String()), "\n") {
+		if strings.HasPrefix(line, "[") {
+			headers = append(headers, line[10:])
+		}
+	}
+	want := []string{
+		"testdata/sample1.go:14 main.handle]",
+		"testdata/sample1.go:14 main.handle id=4bf92f35]",
+		"testdata/sample1.go:14 main.handle id=-]",
+	}
+	if !reflect.DeepEqual(headers, want) {
+		t.Fatalf("\ngot:  %q\nwant: %q", headers, want)
+	}
+}
+
+// TestAbbreviatePackage verifies that abbreviatePackage() shortens every
+// element of the package path but the last.
+func TestAbbreviatePackage(t *testing.T) {
+	testCases := []struct {
+		funcName string
+		want     string
+	}{
+		{"github.com/org/repo/internal/service/db.(*Conn).Query", "g/o/r/i/s/db.(*Conn).Query"},
+		{"github.com/y0ssar1an/q.TestAbbreviatePackage.func1", "g/y/q.TestAbbreviatePackage.func1"},
+		{"net/http.(*Server).Serve", "n/http.(*Server).Serve"},
+		{"main.main", "main.main"},
+		{"", ""},
+	}
+
+	for _, tc := range testCases {
+		if got := abbreviatePackage(tc.funcName); got != tc.want {
+			t.Fatalf("\nabbreviatePackage(%q)\ngot:  %s\nwant: %s", tc.funcName, got, tc.want)
+		}
+	}
+}
+
+// TestHotSiteWarning verifies that logger.print() warns once when a call site
+// is called more often than allowed by SetHotSiteWarning().
+func TestHotSiteWarning(t *testing.T) {
+	l := newLogger()
+	l.hotSitePPS = 3
+
+	c := call{
+		funcName: "main.main",
+		file:     "testdata/sample1.go",
+		line:     14,
+		values:   []interface{}{1, 2, 3, 4, 5, 6, 7},
+	}
+	for i := 0; i < 10; i++ {
+		l.print(c)
+	}
+
+	const want = "sample1.go:14 was called more than 3 times in one second"
+	if n := strings.Count(l.buf.String(), want); n != 1 {
+		t.Fatalf("\ngot %d warnings, want 1:\n%s", n, l.buf.String())
+	}
+}
+
+// TestGroup verifies that logger.print() always prints a header with the
+// group name for QGroup() calls, and that the call after it gets a header too.
+func TestGroup(t *testing.T) {
+	l := newLogger()
+	c := call{
+		funcName: "main.checkpoint",
+		file:     "testdata/sample2.go",
+		line:     15,
+		group:    "checkpoint",
+		skip:     1,
+		values:   []interface{}{123},
+	}
+	l.print(c)
+	l.print(c)
+
+	out := l.buf.String()
+	if n := strings.Count(out, ":15 checkpoint]"); n != 2 {
+		t.Fatalf("\ngot %d headers, want 2:\n%s", n, out)
+	}
+	if want := fmt.Sprintf("%s=%s", colorize("a", bold), colorize("int(123)", cyan)); !strings.Contains(out, want) {
+		t.Fatalf("\ngot:  %q\nmissing: %q", out, want)
+	}
+
+	l.buf.Reset()
+	c.group = ""
+	l.print(c)
+	if h := strings.Count(l.buf.String(), "main.checkpoint]"); h != 1 {
+		t.Fatalf("\ncall after QGroup() got %d headers, want 1:\n%s", h, l.buf.String())
+	}
+}
+
+// TestGroupMarker verifies that the group marker is written before each
+// header, and only before headers.
+func TestGroupMarker(t *testing.T) {
+	l := newLogger()
+	l.groupMarker = "--- q group ---"
+	c := call{
+		funcName: "main.checkpoint",
+		file:     "testdata/sample2.go",
+		line:     15,
+		group:    "checkpoint",
+		skip:     1,
+		values:   []interface{}{123},
+	}
+	l.print(c)
+	c.group = ""
+	l.print(c)
+	l.print(c)
+
+	out := stripColor(l.buf.String())
+	groups := strings.Split(out, "\n--- q group ---\n")
+	if len(groups) != 3 || groups[0] != "" {
+		t.Fatalf("\ngot %d groups, want 2:\n%s", len(groups)-1, out)
+	}
+	for _, g := range groups[1:] {
+		if !strings.HasPrefix(g, "[") {
+			t.Fatalf("\ngroup doesn't start with a header:\n%s", g)
+		}
+	}
+	if n := strings.Count(groups[2], "a=int(123)"); n != 2 {
+		t.Fatalf("\ngot %d calls in the second group, want 2:\n%s", n, groups[2])
+	}
+}
+
+// TestUnique verifies that unique calls only print values that haven't been
+// printed before, and say how many calls were suppressed.
+func TestUnique(t *testing.T) {
+	l := newLogger()
+	for _, v := range []int{1, 2, 1, 1, 2, 3} {
+		l.print(call{
+			funcName: "main.checkpoint",
+			file:     "testdata/sample2.go",
+			line:     15,
+			skip:     1,
+			unique:   true,
+			values:   []interface{}{v},
+		})
+	}
+
+	out := stripColor(l.buf.String())
+	for _, v := range []string{"a=int(1)", "a=int(2)", "a=int(3)"} {
+		if n := strings.Count(out, v); n != 1 {
+			t.Fatalf("\ngot %s %d times, want once:\n%s", v, n, out)
+		}
+	}
+	if !strings.Contains(out, "(seen before, suppressed 3)\n") {
+		t.Fatalf("\ngot:  %s\nwant a summary of the 3 suppressed calls", out)
+	}
+}
+
+// TestShowSpawnSite verifies that the header of a call from a goroutine
+// started by Go() says where it was started.
+func TestShowSpawnSite(t *testing.T) {
+	l := newLogger()
+	l.showSpawn = true
+	c := call{
+		funcName: "main.main",
+		file:     "testdata/sample2.go",
+		line:     9,
+		skip:     1,
+		values:   []interface{}{123, "hello world"},
+	}
+
+	done := make(chan struct{})
+	_, _, line, _ := runtime.Caller(0)
+	Go(func() {
+		defer close(done)
+		l.print(c)
+	})
+	<-done
+
+	header := strings.SplitN(strings.TrimPrefix(stripColor(l.buf.String()), "\n"), "\n", 2)[0]
+	if !strings.HasSuffix(header, " main.main (started at q/q_test.go:"+strconv.Itoa(line+1)+" github.com/y0ssar1an/q.TestShowSpawnSite)]") {
+		t.Fatalf("\ngot:  %s\nwant the site of the call to Go() in the header", header)
+	}
+
+	l.buf.Reset()
+	l.lastFunc = ""
+	l.print(c)
+	if strings.Contains(l.buf.String(), "started at") {
+		t.Fatalf("\ngot:  %s\nwant no start site for a goroutine not started by Go()", l.buf.String())
+	}
+}
+
+// TestFormatPlain verifies that the log file has no ANSI color codes in it
+// with FormatPlain, and that headers are marked with an ASCII rule.
+func TestFormatPlain(t *testing.T) {
+	dir, cleanup := setTempDir(t)
+	defer cleanup()
+
+	l := newLogger()
+	l.format = FormatPlain
+	l.log(call{
+		funcName: "main.main",
+		file:     "testdata/sample2.go",
+		line:     9,
+		note:     "why we're here",
+		skip:     1,
+		values:   []interface{}{123, "hello world"},
+	})
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "q"))
+	if err != nil {
+		t.Fatalf("failed to read log file: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("\ngot %d lines, want 3 (header, note, values):\n%s", len(lines), b)
+	}
+	if strings.Contains(string(b), "\033[") {
+		t.Fatalf("\ngot:  %q\nwant no ANSI escape codes", b)
+	}
+	header := lines[0]
+	if !strings.HasPrefix(header, "-- [") || !strings.Contains(header, " main.main] ---") ||
+		!strings.HasSuffix(header, "-") || len(header) != maxLineWidth {
+		t.Fatalf("\ngot:  %q\nwant a header set off by an ASCII rule", header)
+	}
+	if want := " why we're here"; !strings.HasSuffix(lines[1], want) {
+		t.Fatalf("\ngot:  %q\nwant suffix: %q", lines[1], want)
+	}
+	if want := " a=int(123) b=hello world"; !strings.HasSuffix(lines[2], want) {
+		t.Fatalf("\ngot:  %q\nwant suffix: %q", lines[2], want)
+	}
+}
+
+// TestCallerError verifies that logger.print() marks lines whose caller is
+// unknown, and passes the error to the function set by SetOnCallerError().
+func TestCallerError(t *testing.T) {
+	var gotErr error
+	l := newLogger()
+	l.onCallerErr = func(err error) { gotErr = err }
+
+	callerErr := errors.New("no caller")
+	l.print(call{callerErr: callerErr, values: []interface{}{123}})
+
+	if gotErr != callerErr {
+		t.Fatalf("\ngot:  %v\nwant: %v", gotErr, callerErr)
+	}
+
+	want := fmt.Sprintf(" %s %s\n", colorize(callerUnknown, bold), colorize("int(123)", cyan))
+	if got := l.buf.String(); !strings.HasSuffix(got, want) {
+		t.Fatalf("\ngot:  %q\nwant suffix: %q", got, want)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// repeat keeps track of a value logged from a call site, so it can be
+// suppressed if it's logged again from there within the repeat window. See
+// SetRepeatWindow().
+type repeat struct {
+	start time.Time   // when the value was logged
+	count int         // number of times it's been suppressed since
+	c     call        // the call that logged it, for the summary
+	args  []string    // the name=value strings it logged, for the summary
+	timer *time.Timer // writes the summary when the window closes
+}
+
+// isRepeat returns true if the formatted values in args were logged from the
+// same site within the repeat window, in which case the call is counted and
+// suppressed. Otherwise it starts a new window for them. names are the names
+// of the values.
+//
+// Windows are closed by a timer, which measures real time. With a clock set,
+// or in test mode, that would make the output depend on how fast the program
+// runs, so they're closed by the next call from the site instead.
+func (l *logger) isRepeat(c call, names, args []string) bool {
+	site := l.site(c.file, c.line)
+	if site.repeats == nil {
+		site.repeats = make(map[uint64]*repeat)
+	}
+
+	now := l.now()
+	lazy := l.clock != nil || l.testMode
+	h := hashArgs(args)
+	if r := site.repeats[h]; r != nil {
+		if now.Sub(r.start) < l.repeatWindow {
+			r.count++
+			if r.timer == nil && !lazy {
+				r.timer = time.AfterFunc(l.repeatWindow-now.Sub(r.start), func() {
+					l.endRepeat(site, h, r)
+				})
+			}
+			return true
+		}
+
+		// The window closed, but the summary hasn't been written yet. If the
+		// timer has fired, it's waiting for the lock, and will see that the
+		// value has a new window.
+		if r.count > 0 {
+			if r.timer != nil {
+				r.timer.Stop()
+			}
+			l.writeRepeat(r)
+		}
+		delete(site.repeats, h)
+	}
+
+	// Forget the values whose window has closed, so the map doesn't grow
+	// with every distinct value the site logs. Those closed lazily get their
+	// summaries first, oldest first.
+	var closed []*repeat
+	for k, r := range site.repeats {
+		if r.timer == nil && now.Sub(r.start) >= l.repeatWindow {
+			if r.count > 0 {
+				closed = append(closed, r)
+			}
+			delete(site.repeats, k)
+		}
+	}
+	sort.Slice(closed, func(i, j int) bool { return closed[i].start.Before(closed[j].start) })
+	for _, r := range closed {
+		l.writeRepeat(r)
+	}
+
+	site.repeats[h] = &repeat{start: now, c: c, args: prependArgName(names, args)}
+	return false
+}
+
+// endRepeat writes the summary of a repeated value when its window closes, and
+// forgets the value.
+func (l *logger) endRepeat(site *callSite, h uint64, r *repeat) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if site.repeats[h] != r {
+		// A new window was started after the timer fired.
+		return
+	}
+	delete(site.repeats, h)
+
+	defer l.maybeFlush()
+	start := l.buf.Len()
+	l.writeRepeat(r)
+	if l.noFile {
+		l.buf.Truncate(start)
+	}
+}
+
+// writeRepeat writes a line saying how many times a value was suppressed, under
+// the header of the call that logged it.
+func (l *logger) writeRepeat(r *repeat) {
+	c := r.c
+	c.group = ""
+	l.printHeader(c)
+
+	summary := colorize(fmt.Sprintf("(repeated %d more times within %v)", r.count, l.repeatWindow), bold)
+	l.output(append(r.args, summary)...)
+}
@@ -0,0 +1,121 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRepeatWindow verifies that values repeated from the same site within the
+// repeat window are suppressed, and counted once the window closes, while
+// calls from other sites in between are logged.
+func TestRepeatWindow(t *testing.T) {
+	start := time.Date(2024, 3, 10, 17, 4, 5, 0, time.UTC)
+	now := start
+	l := newLogger()
+	l.repeatWindow = time.Second
+	l.clock = func() time.Time { return now }
+
+	c := call{
+		funcName: "main.main",
+		file:     "testdata/sample1.go",
+		line:     14,
+		names:    []string{"err"},
+	}
+	other := call{
+		funcName: "main.main",
+		file:     "testdata/sample2.go",
+		line:     9,
+		names:    []string{"a"},
+		values:   []interface{}{1},
+	}
+
+	log := func(d time.Duration, c call, v ...interface{}) {
+		now = start.Add(d)
+		if v != nil {
+			c.values = v
+		}
+		l.print(c)
+	}
+	log(0, c, "timeout")
+	log(100*time.Millisecond, c, "timeout")
+	log(200*time.Millisecond, other)
+	log(300*time.Millisecond, c, "refused")
+	log(400*time.Millisecond, c, "timeout")
+	log(1500*time.Millisecond, c, "timeout")
+
+	var got []string
+	for _, line := range strings.Split(stripColor(l.buf.String()), "\n") {
+		if line != "" && !strings.HasPrefix(line, "[") {
+			got = append(got, strings.SplitN(line, " ", 2)[1])
+		}
+	}
+	want := []string{
+		"err=timeout",
+		"a=int(1)",
+		"err=refused",
+		"err=timeout (repeated 2 more times within 1s)",
+		"err=timeout",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("\ngot:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// TestRepeatWindowClock verifies that with a clock set, repeat windows aren't
+// closed by a timer, but by the next call from the site, which writes the
+// summaries of every value whose window has closed, oldest first.
+func TestRepeatWindowClock(t *testing.T) {
+	start := time.Date(2024, 3, 10, 17, 4, 5, 0, time.UTC)
+	now := start
+	l := newLogger()
+	l.repeatWindow = time.Second
+	l.clock = func() time.Time { return now }
+
+	c := call{
+		funcName: "main.main",
+		file:     "testdata/sample1.go",
+		line:     14,
+		names:    []string{"err"},
+	}
+	log := func(d time.Duration, v interface{}) {
+		now = start.Add(d)
+		c.values = []interface{}{v}
+		l.print(c)
+	}
+	log(0, "timeout")
+	log(100*time.Millisecond, "refused")
+	log(200*time.Millisecond, "refused")
+	log(300*time.Millisecond, "timeout")
+
+	for _, site := range l.sites {
+		for _, r := range site.repeats {
+			if r.timer != nil {
+				t.Fatalf("a timer was started for %q", r.args)
+			}
+		}
+	}
+
+	log(5*time.Second, "reset")
+
+	var got []string
+	for _, line := range strings.Split(stripColor(l.buf.String()), "\n") {
+		if line != "" && !strings.HasPrefix(line, "[") {
+			got = append(got, strings.SplitN(line, " ", 2)[1])
+		}
+	}
+	want := []string{
+		"err=timeout",
+		"err=refused",
+		"err=timeout (repeated 1 more times within 1s)",
+		"err=refused (repeated 1 more times within 1s)",
+		"err=reset",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("\ngot:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
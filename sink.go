@@ -0,0 +1,243 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sink is an extra destination for q's output. See AddSink().
+type sink struct {
+	w       io.Writer
+	format  Format
+	tty     bool // w is a terminal
+	inplace bool // the last line written to w is an in-place line. see QInplace().
+}
+
+// clearLine is the ANSI escape code that clears the rest of the line.
+const clearLine = "\033[K"
+
+// isTerminal returns true if w is a terminal, or another character device.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Level is the severity of a call to q. Calls are LevelDebug, except for those
+// logged with QInfo(), QWarn() and QError().
+type Level int
+
+// The levels a call can have.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// String returns the name of the level, e.g. "debug".
+func (lv Level) String() string {
+	if lv < 0 || int(lv) >= len(levelNames) {
+		return "level(" + strconv.Itoa(int(lv)) + ")"
+	}
+	return levelNames[lv]
+}
+
+// MarshalText implements encoding.TextMarshaler, so levels appear in JSON by
+// name.
+func (lv Level) MarshalText() ([]byte, error) {
+	return []byte(lv.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. It accepts the names
+// returned by String().
+func (lv *Level) UnmarshalText(text []byte) error {
+	for i, name := range levelNames {
+		if string(text) == name {
+			*lv = Level(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("q: unknown level %q", text)
+}
+
+// Record is the data of one call to q, for programs that want the data rather
+// than the text. See SetRecordSink().
+type Record struct {
+	Time  time.Time `json:"time"`
+	Seq   uint64    `json:"seq"` // see Sequence()
+	Level Level     `json:"level"`
+	Func  string    `json:"func,omitempty"` // function that called q, e.g. main.main
+	File  string    `json:"file,omitempty"`
+	Line  int       `json:"line,omitempty"`
+	Group string    `json:"group,omitempty"` // the name given to QGroup()
+	Note  string    `json:"note,omitempty"`  // the note given to Qn()
+	Pairs []Pair    `json:"pairs"`
+}
+
+// Pair is one of the values in a Record. Value is the pretty-printed value,
+// without colors. Name is the name of the value in the source, if it has one.
+type Pair struct {
+	Name  string `json:"name,omitempty"`
+	Value string `json:"value"`
+}
+
+// record returns the record of the call, given the names of its values and
+// the formatted values.
+func (l *logger) record(c call, names, args []string) Record {
+	rec := Record{
+		Time:  l.now(),
+		Seq:   c.seq,
+		Level: c.level,
+		Func:  c.funcName,
+		File:  c.file,
+		Line:  c.line,
+		Group: c.group,
+		Note:  c.note,
+		Pairs: make([]Pair, len(args)),
+	}
+	for i, arg := range args {
+		if i < len(names) {
+			rec.Pairs[i].Name = names[i]
+		}
+		rec.Pairs[i].Value = stripColor(arg)
+	}
+	return rec
+}
+
+// writeSinks writes the output of a call to each sink, in the sink's format.
+// text is the call's output in the log file, body the part of it after the
+// header, and rec its record. If inplace is true, text sinks that are
+// terminals get just the body, over the previous in-place line. Errors are
+// ignored, as they are when writing the log file.
+func (l *logger) writeSinks(text, body string, inplace bool, rec Record) {
+	var js []byte
+	for i := range l.sinks {
+		s := &l.sinks[i]
+		if s.tty && s.format != FormatJSON {
+			if inplace {
+				line := "\r" + strings.TrimSuffix(body, "\n") + clearLine
+				if s.format == FormatPlain {
+					line = stripColor(line)
+				}
+				io.WriteString(s.w, line)
+				s.inplace = true
+				continue
+			}
+			if s.inplace {
+				// Keep the last in-place line, and start a new one.
+				io.WriteString(s.w, "\n")
+				s.inplace = false
+			}
+		}
+
+		switch s.format {
+		case FormatJSON:
+			if js == nil {
+				b, err := json.Marshal(rec)
+				if err != nil {
+					continue
+				}
+				js = append(b, '\n')
+			}
+			s.w.Write(js)
+		case FormatPlain:
+			io.WriteString(s.w, stripColor(text))
+		default:
+			io.WriteString(s.w, text)
+		}
+	}
+}
+
+// AddSink makes q write its output to w too, in the given format, e.g.
+//
+//	q.AddSink(os.Stdout, q.FormatJSON)
+//
+// sends every call to stdout as a line of JSON, while the log file keeps the
+// pretty, colorized output. FormatText sinks get exactly what's written to the
+// log file, and FormatPlain sinks get it without colors. Output captured by
+// CaptureStd(), and output dumped by QbufDump(), only goes to the log file.
+//
+// Sinks aren't free: each one costs an extra write per call, and the first
+// FormatJSON sink costs encoding the call as JSON. They're written while q
+// holds its lock, so a slow writer slows down every goroutine that calls q.
+func AddSink(w io.Writer, f Format) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.sinks = append(std.sinks, sink{w: w, format: f, tty: isTerminal(w)})
+}
+
+// SetRecordSink sets a function that's called with the record of every call to
+// Q() and friends, e.g. to feed the data to metrics or a database without
+// parsing q's text. It's called before the text is written, which still
+// happens unless it's turned off with SetFileOutput(false). nil, the default,
+// disables it.
+//
+// f runs on the hot path, while q holds its lock, so it should be fast, and
+// must not call q.
+func SetRecordSink(f func(Record)) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.recordSink = f
+}
+
+// SetForward sets a function that's called with every call to Q() and friends,
+// to forward it to another logger, e.g.
+//
+//	q.SetForward(func(level q.Level, msg string, pairs []q.Pair) {
+//		attrs := make([]any, len(pairs))
+//		for i, p := range pairs {
+//			attrs[i] = slog.String(p.Name, p.Value)
+//		}
+//		logger.Debug(msg, attrs...)
+//	})
+//
+// It gets structured data, not text: the level of the call, a message, and
+// each value as a name and its pretty-printed form, without colors. The
+// message is the note given to Qn(), or the name given to QGroup(), or else
+// where q was called from, e.g. "main.go:42 main.main". The log file is still
+// written, unless it's turned off with SetFileOutput(false). For the rest of
+// the call's data, use SetRecordSink(). nil, the default, disables it.
+//
+// Like the record sink, f runs while q holds its lock, so it should be fast,
+// and must not call q.
+func SetForward(f func(level Level, msg string, pairs []Pair)) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.forward = f
+}
+
+// forwardMsg returns the message that SetForward() sends for a record.
+func forwardMsg(r Record) string {
+	switch {
+	case r.Note != "":
+		return r.Note
+	case r.Group != "":
+		return r.Group
+	case r.File == "":
+		return callerUnknown
+	}
+	return fmt.Sprintf("%s:%d %s", shortFile(r.File), r.Line, r.Func)
+}
+
+// SetFileOutput turns writing the log file on or off. Turn it off to only send
+// q's output to sinks. See AddSink() and SetRecordSink(). It's on by default.
+func SetFileOutput(on bool) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.noFile = !on
+}
@@ -0,0 +1,174 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestSinks verifies that each sink gets the output of a call in its own
+// format.
+func TestSinks(t *testing.T) {
+	dir, cleanup := setTempDir(t)
+	defer cleanup()
+
+	var text, plain, js bytes.Buffer
+	l := newLogger()
+	l.clock = func() time.Time { return time.Date(2024, 3, 10, 17, 4, 5, 0, time.UTC) }
+	l.sinks = []sink{{w: &text, format: FormatText}, {w: &plain, format: FormatPlain}, {w: &js, format: FormatJSON}}
+	l.log(call{
+		funcName: "main.main",
+		file:     "testdata/sample2.go",
+		line:     9,
+		note:     "why we're here",
+		skip:     1,
+		values:   []interface{}{123, "hello world"},
+	})
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "q"))
+	if err != nil {
+		t.Fatalf("failed to read log file: %v", err)
+	}
+	if got, want := text.String(), string(b); got != want {
+		t.Fatalf("\nFormatText sink\ngot:  %q\nwant: %q", got, want)
+	}
+	if got, want := plain.String(), stripColor(string(b)); got != want {
+		t.Fatalf("\nFormatPlain sink\ngot:  %q\nwant: %q", got, want)
+	}
+
+	var got Record
+	if err := json.Unmarshal(js.Bytes(), &got); err != nil {
+		t.Fatalf("FormatJSON sink got invalid JSON %q: %v", js.String(), err)
+	}
+	want := Record{
+		Time:  time.Date(2024, 3, 10, 17, 4, 5, 0, time.UTC),
+		Seq:   got.Seq,
+		Level: LevelDebug,
+		Func:  "main.main",
+		File:  "testdata/sample2.go",
+		Line:  9,
+		Note:  "why we're here",
+		Pairs: []Pair{
+			{Name: "a", Value: "int(123)"},
+			{Name: "b", Value: "hello world"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("\nFormatJSON sink\ngot:  %+v\nwant: %+v", got, want)
+	}
+	if got.Seq == 0 {
+		t.Fatalf("FormatJSON sink got no sequence number")
+	}
+}
+
+// TestRecordSink verifies that the record sink gets the record of each call,
+// and that the log file isn't written when noFile is set.
+func TestRecordSink(t *testing.T) {
+	dir, cleanup := setTempDir(t)
+	defer cleanup()
+
+	var got []Record
+	l := newLogger()
+	l.recordSink = func(r Record) { got = append(got, r) }
+	l.noFile = true
+	l.log(call{
+		funcName: "main.checkpoint",
+		file:     "testdata/sample2.go",
+		line:     15,
+		group:    "checkpoint",
+		skip:     1,
+		values:   []interface{}{123},
+	})
+
+	if len(got) != 1 {
+		t.Fatalf("record sink got %d records, want 1", len(got))
+	}
+	want := []Pair{{Name: "a", Value: "int(123)"}}
+	if r := got[0]; r.Group != "checkpoint" || r.Line != 15 || !reflect.DeepEqual(r.Pairs, want) {
+		t.Fatalf("\ngot:  %+v\nwant: group checkpoint, line 15, pairs %+v", r, want)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "q")); !os.IsNotExist(err) {
+		t.Fatalf("log file was written with the file output off")
+	}
+}
+
+// TestForward verifies that the forward function gets the level, message, and
+// pairs of each call.
+func TestForward(t *testing.T) {
+	type forwarded struct {
+		level Level
+		msg   string
+		pairs []Pair
+	}
+
+	var got []forwarded
+	l := newLogger()
+	l.forward = func(level Level, msg string, pairs []Pair) {
+		got = append(got, forwarded{level, msg, pairs})
+	}
+
+	c := call{
+		funcName: "main.main",
+		file:     "/src/testdata/sample2.go",
+		line:     9,
+		names:    []string{"a", "b"},
+		values:   []interface{}{123, "hello world"},
+	}
+	l.print(c)
+	c.note = "why we're here"
+	l.print(c)
+
+	pairs := []Pair{{Name: "a", Value: "int(123)"}, {Name: "b", Value: "hello world"}}
+	want := []forwarded{
+		{LevelDebug, "testdata/sample2.go:9 main.main", pairs},
+		{LevelDebug, "why we're here", pairs},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("\ngot:  %+v\nwant: %+v", got, want)
+	}
+}
+
+// TestInplace verifies that in-place calls overwrite each other on terminal
+// sinks, and are written like any other call elsewhere.
+func TestInplace(t *testing.T) {
+	var tty, file bytes.Buffer
+	l := newLogger()
+	l.noFile = true
+	l.clock = func() time.Time { return time.Date(2024, 3, 10, 17, 4, 5, 0, time.UTC) }
+	l.sinks = []sink{{w: &tty, format: FormatPlain, tty: true}, {w: &file, format: FormatPlain}}
+
+	c := call{
+		funcName: "main.main",
+		file:     "testdata/sample1.go",
+		line:     14,
+		names:    []string{"i"},
+		inplace:  true,
+	}
+	for i := 0; i < 2; i++ {
+		c.values = []interface{}{i}
+		l.log(c)
+	}
+	c.inplace = false
+	c.values = []interface{}{2}
+	l.log(c)
+
+	want := "\r0.000s i=int(0)\033[K\r0.000s i=int(1)\033[K\n0.000s i=int(2)\n"
+	if got := tty.String(); got != want {
+		t.Fatalf("\nterminal sink\ngot:  %q\nwant: %q", got, want)
+	}
+	want = "\n[17:04:05 testdata/sample1.go:14 main.main]\n" +
+		"0.000s i=int(0)\n0.000s i=int(1)\n0.000s i=int(2)\n"
+	if got := file.String(); got != want {
+		t.Fatalf("\nfile sink\ngot:  %q\nwant: %q", got, want)
+	}
+}
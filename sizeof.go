@@ -0,0 +1,148 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"reflect"
+	"time"
+	"unsafe"
+)
+
+// mapHeaderSize is roughly the size of the header of a map, which a map value
+// points to. The buckets are estimated from the size of the keys and elements.
+const mapHeaderSize = 48
+
+// locationType is the type of the time zone a time.Time points to. Time zones
+// are shared by every time in them, so they aren't counted in their size.
+var locationType = reflect.TypeOf(time.Location{})
+
+// QSizeof is like Q, but each value is preceded by an estimate of how much
+// memory it takes up, including everything it points to, e.g.
+//
+//	cache=~1.2 MiB map[string][]uint8{...}
+//
+// It helps to find out which structure is unexpectedly large. The estimate is
+// approximate: it counts the memory the values would take if laid out plainly,
+// not what the allocator and the runtime actually use for them. Memory
+// reachable in more than one way, e.g. two pointers to the same struct, or two
+// slices of the same array, is counted once. Time zones, which are shared by
+// every time.Time in them, aren't counted.
+func QSizeof(v ...interface{}) {
+	if std.quiet.Load() {
+		return
+	}
+	funcName, file, line, err := getCallerInfo()
+	std.log(call{
+		funcName:  funcName,
+		file:      file,
+		line:      line,
+		callerErr: err,
+		sizeof:    true,
+		values:    v,
+	})
+}
+
+// sizeof estimates the memory taken up by v, including everything it points
+// to. See QSizeof().
+func sizeof(v interface{}) int64 {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return 0
+	}
+	s := &sizer{visited: make(map[uintptr]bool)}
+	return int64(rv.Type().Size()) + s.deep(rv)
+}
+
+// sizer adds up the memory reachable from a value. visited holds the addresses
+// already counted, so shared memory is counted once and cycles end.
+type sizer struct {
+	visited map[uintptr]bool
+}
+
+// visit returns true if the memory at addr hasn't been counted yet, and
+// remembers that it has now.
+func (s *sizer) visit(addr uintptr) bool {
+	if addr == 0 || s.visited[addr] {
+		return false
+	}
+	s.visited[addr] = true
+	return true
+}
+
+// deep returns the size of the memory v points to, not counting v itself,
+// whose size is counted as part of whatever holds it.
+func (s *sizer) deep(v reflect.Value) int64 {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() || v.Type().Elem() == locationType || !s.visit(v.Pointer()) {
+			return 0
+		}
+		return int64(v.Type().Elem().Size()) + s.deep(v.Elem())
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		e := v.Elem()
+		switch e.Kind() {
+		case reflect.Ptr, reflect.Map, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+			// Pointer shaped values are stored in the interface itself.
+			return s.deep(e)
+		}
+		return int64(e.Type().Size()) + s.deep(e)
+
+	case reflect.String:
+		str := v.String()
+		if len(str) == 0 || !s.visit(uintptr(unsafe.Pointer(unsafe.StringData(str)))) {
+			return 0
+		}
+		return int64(len(str))
+
+	case reflect.Slice:
+		if v.IsNil() || !s.visit(v.Pointer()) {
+			return 0
+		}
+		n := int64(v.Cap()) * int64(v.Type().Elem().Size())
+		for i := 0; i < v.Len(); i++ {
+			n += s.deep(v.Index(i))
+		}
+		return n
+
+	case reflect.Array:
+		var n int64
+		for i := 0; i < v.Len(); i++ {
+			n += s.deep(v.Index(i))
+		}
+		return n
+
+	case reflect.Struct:
+		var n int64
+		for i := 0; i < v.NumField(); i++ {
+			n += s.deep(v.Field(i))
+		}
+		return n
+
+	case reflect.Map:
+		if v.IsNil() || !s.visit(v.Pointer()) {
+			return 0
+		}
+		t := v.Type()
+		n := mapHeaderSize + int64(v.Len())*int64(t.Key().Size()+t.Elem().Size())
+		iter := v.MapRange()
+		for iter.Next() {
+			n += s.deep(iter.Key()) + s.deep(iter.Value())
+		}
+		return n
+
+	case reflect.Chan:
+		if v.IsNil() || !s.visit(v.Pointer()) {
+			return 0
+		}
+		// The buffered elements can't be read without receiving them, so
+		// only the buffer itself is counted.
+		return int64(v.Cap()) * int64(v.Type().Elem().Size())
+	}
+	return 0
+}
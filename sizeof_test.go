@@ -0,0 +1,73 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSizeof verifies that sizeof() adds up the memory a value points to,
+// counting shared memory once, and ending cycles.
+func TestSizeof(t *testing.T) {
+	type node struct {
+		next *node
+		v    int
+	}
+	loop := &node{v: 1}
+	loop.next = loop
+
+	arr := &[100]byte{}
+	shared := struct{ a, b *[100]byte }{arr, arr}
+
+	word := int64(reflect.TypeOf(0).Size())
+	str := int64(reflect.TypeOf("").Size())
+	slice := int64(reflect.TypeOf([]int{}).Size())
+
+	testCases := []struct {
+		id   int
+		arg  interface{}
+		want int64
+	}{
+		{1, nil, 0},
+		{2, 5, word},
+		{3, "hello", str + 5},
+		{4, []int64{1, 2, 3}, slice + 24},
+		{5, make([]int64, 1, 4), slice + 32},
+		{6, []string{"ab", "cd"}, slice + 2*str + 4},
+		{7, shared, 2*word + 100},
+		{8, loop, word + int64(reflect.TypeOf(node{}).Size())},
+		{9, time.Now(), int64(reflect.TypeOf(time.Time{}).Size())},
+		{10, map[int64]int64{1: 2}, word + mapHeaderSize + 16},
+		{11, []interface{}{int64(1)}, slice + 2*word + 8},
+	}
+
+	for _, tc := range testCases {
+		if got := sizeof(tc.arg); got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:  %d\nwant: %d", tc.id, got, tc.want)
+		}
+	}
+}
+
+// TestQSizeof verifies that QSizeof() calls print the size of each value
+// before the value.
+func TestQSizeof(t *testing.T) {
+	l := newLogger()
+	l.print(call{
+		funcName: "main.main",
+		file:     "testdata/sample1.go",
+		line:     14,
+		sizeof:   true,
+		values:   []interface{}{make([]byte, 2048)[:2]},
+	})
+
+	got := stripColor(l.buf.String())
+	want := "a=~2.0 KiB []uint8{0x0, 0x0}"
+	if !strings.Contains(got, want) {
+		t.Fatalf("\ngot:  %s\nwant: %s", got, want)
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+)
+
+// maxSliceDiffCells limits the size of the table used to match up the
+// elements of two versions of a slice. Longer slices are compared index by
+// index instead.
+const maxSliceDiffCells = 1 << 20
+
+// QSliceDiff logs how the slice s has changed since the last time QSliceDiff
+// was called from the same line, e.g. to watch a work queue grow and shrink:
+//
+//	queue[0]=- "job 1"
+//	queue[3]=+ "job 4"
+//	queue[1]="job 2" -> "job 2 (retry)"
+//
+// Indexes are those of the previous version for removed elements, and of the
+// new one for the others. The first call from a line, and any call where s has
+// a different type or length than last time, logs the whole slice.
+func QSliceDiff(s interface{}) {
+	if std.quiet.Load() {
+		return
+	}
+	funcName, file, line, err := getCallerInfo()
+	std.log(call{
+		funcName:  funcName,
+		file:      file,
+		line:      line,
+		callerErr: err,
+		sliceDiff: true,
+		values:    []interface{}{s},
+	})
+}
+
+// diffSlice replaces the slice in c with the changes since the previous call
+// from the same site, and remembers it for the next one. If there is nothing
+// to compare it with, or its type or length has changed, c is left as is, so
+// the whole slice is printed.
+func (l *logger) diffSlice(c *call) {
+	v := reflect.ValueOf(c.values[0])
+	if c.callerErr != nil || !v.IsValid() || v.Kind() != reflect.Slice {
+		return
+	}
+
+	elems := make([]string, v.Len())
+	for i := range elems {
+		elems[i] = formatElem(v.Index(i), l.opts)
+	}
+
+	site := l.site(c.file, c.line)
+	prev, prevType := site.prevSlice, site.prevType
+	site.prevSlice, site.prevType = elems, v.Type()
+	if prevType != v.Type() || len(prev) != len(elems) {
+		return
+	}
+
+	name := "s"
+	if names, err := l.argNames(c.file, c.line); err == nil && len(names) == 1 {
+		name = names[0]
+	}
+
+	c.names, c.values = nil, nil
+	for _, e := range sliceEdits(prev, elems) {
+		var s string
+		switch {
+		case e.old < 0:
+			s = "+ " + elems[e.new]
+		case e.new < 0:
+			s = "- " + prev[e.old]
+		default:
+			s = prev[e.old] + " -> " + elems[e.new]
+		}
+		i := e.new
+		if i < 0 {
+			i = e.old
+		}
+		c.names = append(c.names, fmt.Sprintf("%s[%d]", name, i))
+		c.values = append(c.values, s)
+	}
+
+	if len(c.values) == 0 {
+		c.names = []string{name}
+		c.values = []interface{}{fmt.Sprintf("no changes, len %d", len(elems))}
+	}
+	c.lines = true
+}
+
+// sliceEdit is one difference between two versions of a slice: an element
+// that was removed (new < 0), inserted (old < 0), or changed.
+type sliceEdit struct {
+	old, new int
+}
+
+// sliceEdits returns the edits that turn a into b. Elements are matched up by
+// their longest common subsequence, so an element removed from the front
+// doesn't make every element after it look changed. A removal and an insertion
+// at the same spot are reported as a change.
+func sliceEdits(a, b []string) []sliceEdit {
+	if len(a)*len(b) > maxSliceDiffCells {
+		return indexEdits(a, b)
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and
+	// b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var edits, removed, inserted []sliceEdit
+	// pair flushes the removals and insertions since the last common element,
+	// pairing them up as changes.
+	pair := func() {
+		for len(removed) > 0 && len(inserted) > 0 {
+			edits = append(edits, sliceEdit{removed[0].old, inserted[0].new})
+			removed, inserted = removed[1:], inserted[1:]
+		}
+		edits = append(edits, removed...)
+		edits = append(edits, inserted...)
+		removed, inserted = nil, nil
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			pair()
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			removed = append(removed, sliceEdit{i, -1})
+			i++
+		default:
+			inserted = append(inserted, sliceEdit{-1, j})
+			j++
+		}
+	}
+	pair()
+	return edits
+}
+
+// indexEdits returns the edits that turn a into b, comparing elements at the
+// same index.
+func indexEdits(a, b []string) []sliceEdit {
+	var edits []sliceEdit
+	for i := 0; i < len(a) || i < len(b); i++ {
+		switch {
+		case i >= len(a):
+			edits = append(edits, sliceEdit{-1, i})
+		case i >= len(b):
+			edits = append(edits, sliceEdit{i, -1})
+		case a[i] != b[i]:
+			edits = append(edits, sliceEdit{i, i})
+		}
+	}
+	return edits
+}
+
+// formatElem pretty-prints an element of a slice the way it's printed inside
+// the slice, i.e. without its type, and with strings quoted.
+func formatElem(v reflect.Value, opts formatOptions) (s string) {
+	defer func() {
+		if r := recover(); r != nil {
+			s = fmt.Sprintf("<panic formatting %s: %v>", v.Type(), r)
+		}
+	}()
+
+	var buf bytes.Buffer
+	w := newTabWriter(&buf)
+	p := &valuePrinter{Writer: w, tw: w, visited: make(map[visit]int), opts: opts}
+	p.printValue(v, false, true)
+	w.Flush()
+	return buf.String()
+}
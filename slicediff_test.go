@@ -0,0 +1,78 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestSliceEdits verifies that sliceEdits() matches up the elements that two
+// slices have in common, and pairs up the rest as changes where it can.
+func TestSliceEdits(t *testing.T) {
+	testCases := []struct {
+		id   int
+		a, b []string
+		want []sliceEdit
+	}{
+		{1, []string{"a", "b"}, []string{"a", "b"}, nil},
+		{2, []string{"a", "b"}, []string{"a", "b", "c"}, []sliceEdit{{-1, 2}}},
+		{3, []string{"a", "b", "c"}, []string{"b", "c"}, []sliceEdit{{0, -1}}},
+		{4, []string{"a", "b", "c"}, []string{"a", "x", "c"}, []sliceEdit{{1, 1}}},
+		{5, []string{"a", "b", "c"}, []string{"b", "c", "d"}, []sliceEdit{{0, -1}, {-1, 2}}},
+		{6, nil, []string{"a"}, []sliceEdit{{-1, 0}}},
+	}
+
+	for _, tc := range testCases {
+		if got := sliceEdits(tc.a, tc.b); !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("\nTEST %d\ngot:  %v\nwant: %v", tc.id, got, tc.want)
+		}
+	}
+}
+
+// TestSliceDiff verifies that QSliceDiff() calls print the whole slice the
+// first time, and when its type or length changes, and only what changed
+// otherwise.
+func TestSliceDiff(t *testing.T) {
+	l := newLogger()
+	c := call{
+		funcName:  "main.main",
+		file:      "testdata/sample3.go",
+		line:      7,
+		sliceDiff: true,
+	}
+
+	testCases := []struct {
+		id   int
+		arg  interface{}
+		want string
+	}{
+		{1, []string{"job 1", "job 2", "job 3"}, `queue=[]string{"job 1", "job 2", "job 3"}`},
+		{2, []string{"job 2", "job 3 (retry)", "job 4"}, `queue[0]=- "job 1"` + "\n" +
+			`queue[1]="job 3" -> "job 3 (retry)"` + "\n" +
+			`queue[2]=+ "job 4"`},
+		{3, []string{"job 2", "job 3 (retry)", "job 4"}, "queue=no changes, len 3"},
+		{4, []string{"job 3 (retry)", "job 4"}, `queue=[]string{"job 3 (retry)", "job 4"}`},
+		{5, []string{"job 3 (retry)", "job 5"}, `queue[1]="job 4" -> "job 5"`},
+		{6, []int{1, 2}, "queue=[]int{1, 2}"},
+	}
+
+	for _, tc := range testCases {
+		l.buf.Reset()
+		c.values = []interface{}{tc.arg}
+		l.print(c)
+
+		var lines []string
+		for _, line := range strings.Split(strings.TrimSpace(stripColor(l.buf.String())), "\n") {
+			if !strings.HasPrefix(line, "[") && line != "" {
+				lines = append(lines, strings.SplitN(line, " ", 2)[1])
+			}
+		}
+		if got := strings.Join(lines, "\n"); got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:  %s\nwant: %s", tc.id, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package q
+
+import (
+	"io"
+	"log/slog"
+	"reflect"
+)
+
+var (
+	slogAttrType  = reflect.TypeOf(slog.Attr{})
+	slogValueType = reflect.TypeOf(slog.Value{})
+)
+
+func init() {
+	printSlog = func(p *valuePrinter, v reflect.Value, showType bool) bool {
+		switch v.Type() {
+		case slogAttrType:
+			p.printAttr(v.Interface().(slog.Attr))
+		case slogValueType:
+			p.printSlogValue(v.Interface().(slog.Value), showType)
+		default:
+			return false
+		}
+		return true
+	}
+}
+
+// printAttr prints a slog.Attr as key=value.
+func (p *valuePrinter) printAttr(a slog.Attr) {
+	io.WriteString(p, a.Key)
+	writeByte(p, '=')
+	p.printSlogValue(a.Value, false)
+}
+
+// printSlogValue prints the resolved form of a slog.Value. Groups are printed as
+// {key=value, key=value}.
+func (p *valuePrinter) printSlogValue(v slog.Value, showType bool) {
+	v = v.Resolve()
+	if v.Kind() != slog.KindGroup {
+		// Unlike the other kinds, KindAny doesn't say what type the value is.
+		showType = showType || v.Kind() == slog.KindAny
+		p.printValue(reflect.ValueOf(v.Any()), showType, true)
+		return
+	}
+
+	writeByte(p, '{')
+	for i, a := range v.Group() {
+		if i > 0 {
+			io.WriteString(p, ", ")
+		}
+		p.printAttr(a)
+	}
+	writeByte(p, '}')
+}
@@ -0,0 +1,64 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package q
+
+import (
+	"log/slog"
+	"testing"
+)
+
+// TestFormatSlog verifies that formatValue() prints slog.Attr as key=value and
+// slog.Value as its resolved value.
+func TestFormatSlog(t *testing.T) {
+	testCases := []struct {
+		id   int
+		arg  interface{}
+		want string
+	}{
+		{
+			id:   1,
+			arg:  slog.Int("id", 5),
+			want: "id=5",
+		},
+		{
+			id:   2,
+			arg:  slog.String("name", "bob"),
+			want: `name="bob"`,
+		},
+		{
+			id:   3,
+			arg:  slog.Group("req", slog.String("method", "GET"), slog.Group("url", slog.String("path", "/"))),
+			want: `req={method="GET", url={path="/"}}`,
+		},
+		{
+			id:   4,
+			arg:  slog.IntValue(5),
+			want: "int64(5)",
+		},
+		{
+			id:   5,
+			arg:  slog.AnyValue(logValuer{}),
+			want: `"resolved"`,
+		},
+		{
+			id:   6,
+			arg:  []slog.Attr{slog.Bool("ok", true), slog.Any("p", point{x: 1})},
+			want: "[]slog.Attr{\n    ok=true,\n    p=q.point{x:1, y:0},\n}",
+		},
+	}
+
+	for _, tc := range testCases {
+		if got := formatValue(tc.arg, formatOptions{}); got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:  %s\nwant: %s", tc.id, got, tc.want)
+		}
+	}
+}
+
+// logValuer is a slog.LogValuer, for testing that slog values are resolved.
+type logValuer struct{}
+
+func (logValuer) LogValue() slog.Value { return slog.StringValue("resolved") }
@@ -0,0 +1,639 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/textproto"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// This file has the printers for types that q doesn't print field by field,
+// because a more readable form is available.
+
+// redacted replaces sensitive values in the output. See SetRedaction().
+const redacted = "<redacted>"
+
+// secret is a string that's printed as <redacted> unless redaction is off.
+type secret string
+
+// opaqueTypes are types whose fields are unexported runtime internals that are
+// of no use when debugging. They're printed as T{...}, the same way functions
+// are printed without their body.
+var opaqueTypes = map[reflect.Type]bool{
+	reflect.TypeOf(time.Timer{}):  true,
+	reflect.TypeOf(time.Ticker{}): true,
+}
+
+var (
+	errorType      = reflect.TypeOf((*error)(nil)).Elem()
+	multiErrorType = reflect.TypeOf((*multiError)(nil)).Elem()
+	rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+	timeType       = reflect.TypeOf(time.Time{})
+	secretType     = reflect.TypeOf(secret(""))
+	formatterType  = reflect.TypeOf((*fmt.Formatter)(nil)).Elem()
+	contextType    = reflect.TypeOf((*context.Context)(nil)).Elem()
+	fileInfoType   = reflect.TypeOf((*fs.FileInfo)(nil)).Elem()
+)
+
+// printSlog prints v if it's a slog.Attr or a slog.Value, and returns true if
+// it did. It's set in slog.go, since log/slog needs Go 1.21, and is nil before
+// that.
+var printSlog func(p *valuePrinter, v reflect.Value, showType bool) bool
+
+// multiError is implemented by errors that wrap several errors, like the ones
+// returned by errors.Join().
+type multiError interface {
+	error
+	Unwrap() []error
+}
+
+// sensitiveHeaders are the HTTP headers whose values are redacted.
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Cookie":              true,
+	"Proxy-Authorization": true,
+	"Set-Cookie":          true,
+}
+
+// sensitiveEnvWords are the words that mark an environment variable as
+// sensitive, if its name contains one of them. See QEnv().
+var sensitiveEnvWords = []string{"PASSWORD", "SECRET", "TOKEN", "KEY"}
+
+// isSensitiveEnv returns true if the value of the environment variable with the
+// given name should be redacted.
+func isSensitiveEnv(name string) bool {
+	name = strings.ToUpper(name)
+	for _, w := range sensitiveEnvWords {
+		if strings.Contains(name, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// printSpecial prints v if it's one of the types in this file, and returns
+// true if it did.
+func (p *valuePrinter) printSpecial(v reflect.Value, showType bool) bool {
+	if !v.IsValid() {
+		return false
+	}
+
+	t := v.Type()
+	switch {
+	case t == secretType:
+		if p.opts.showSecrets {
+			io.WriteString(p, v.String())
+		} else {
+			io.WriteString(p, redacted)
+		}
+	case t == waitGroupType:
+		p.printWaitGroup(v, showType)
+	case opaqueTypes[t]:
+		if showType {
+			io.WriteString(p, p.typeName(t))
+		}
+		io.WriteString(p, "{...}")
+	case isNamed(t, "net/http", "Header"):
+		p.printMultiValueMap(v, showType, true)
+	case isNamed(t, "net/url", "Values"):
+		p.printMultiValueMap(v, showType, false)
+	case printSlog != nil && printSlog(p, v, showType):
+	case t == rawMessageType:
+		return p.printJSON(v, showType)
+	case p.opts.utf8Bytes && isByteSlice(v) && utf8.Valid(v.Bytes()):
+		p.printBytesString(v, showType)
+	case (p.opts.hexDump || p.opts.utf8Bytes) && isByteSlice(v):
+		p.printHexDump(v, showType)
+	case p.isFlags(v):
+		p.printFlags(v, showType)
+	case isContext(v):
+		p.printContext(v.Interface().(context.Context), showType)
+	case isFileInfo(v):
+		p.printFileInfo(v.Interface().(fs.FileInfo), showType)
+	case t == timeType && v.CanInterface():
+		p.printTime(v.Interface().(time.Time), showType)
+	case isErrorList(v):
+		errs := make([]error, v.Len())
+		for i := range errs {
+			errs[i], _ = v.Index(i).Interface().(error)
+		}
+		p.printErrors(p.typeName(t), errs, showType)
+	case isMultiError(v):
+		p.printErrors(p.typeName(t), v.Interface().(multiError).Unwrap(), showType)
+	case isFormatter(v):
+		p.printFormatter(v, showType)
+	default:
+		return false
+	}
+	return true
+}
+
+// isNamed returns true if t is the type pkgPath.name. It lets q recognize types
+// from packages it doesn't import.
+func isNamed(t reflect.Type, pkgPath, name string) bool {
+	return t.PkgPath() == pkgPath && t.Name() == name
+}
+
+// printMultiValueMap prints a map[string][]string, like url.Values or
+// http.Header, as aligned "Key: v1, v2" lines sorted by key. If isHeader is
+// true, the keys are canonicalized, and the values of sensitive headers are
+// redacted unless redaction is off.
+func (p *valuePrinter) printMultiValueMap(v reflect.Value, showType, isHeader bool) {
+	if showType {
+		io.WriteString(p, p.typeName(v.Type()))
+	}
+	writeByte(p, '{')
+	if v.Len() == 0 {
+		writeByte(p, '}')
+		return
+	}
+
+	values := make(map[string][]string, v.Len())
+	for _, k := range v.MapKeys() {
+		key := k.String()
+		if isHeader {
+			key = textproto.CanonicalMIMEHeaderKey(key)
+		}
+		vals := v.MapIndex(k)
+		for i := 0; i < vals.Len(); i++ {
+			values[key] = append(values[key], vals.Index(i).String())
+		}
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	writeByte(p, '\n')
+	pp := p.indent()
+	for _, k := range keys {
+		val := strings.Join(values[k], ", ")
+		if isHeader && sensitiveHeaders[k] && !p.opts.showSecrets {
+			val = redacted
+		}
+		io.WriteString(pp, k)
+		io.WriteString(pp, ":\t")
+		io.WriteString(pp, val)
+		writeByte(pp, '\n')
+	}
+	pp.tw.Flush()
+	writeByte(p, '}')
+}
+
+// isErrorList returns true if v is a non-nil slice or array of errors.
+func isErrorList(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.IsNil() {
+			return false
+		}
+	case reflect.Array:
+	default:
+		return false
+	}
+	return v.CanInterface() && v.Type().Elem().Implements(errorType)
+}
+
+// isMultiError returns true if v wraps several errors, like the errors
+// returned by errors.Join().
+func isMultiError(v reflect.Value) bool {
+	if !v.CanInterface() || !v.Type().Implements(multiErrorType) {
+		return false
+	}
+	return v.Kind() != reflect.Ptr || !v.IsNil()
+}
+
+// printErrors prints each error on its own line. Errors that wrap several
+// errors are expanded the same way, one level deeper.
+func (p *valuePrinter) printErrors(typeName string, errs []error, showType bool) {
+	if showType {
+		io.WriteString(p, typeName)
+	}
+	writeByte(p, '{')
+	if len(errs) == 0 {
+		writeByte(p, '}')
+		return
+	}
+
+	writeByte(p, '\n')
+	pp := p.indent()
+	for _, err := range errs {
+		switch e := err.(type) {
+		case nil:
+			io.WriteString(pp, "nil")
+		case multiError:
+			pp.printErrors(pp.typeName(reflect.TypeOf(e)), e.Unwrap(), true)
+		default:
+			pp.fmtString(e.Error(), true)
+		}
+		io.WriteString(pp, ",\n")
+	}
+	pp.tw.Flush()
+	writeByte(p, '}')
+}
+
+// Colors of the keys and strings in JSON, if the value around them is colored.
+// See printJSON().
+const (
+	jsonKeyColor    = Blue
+	jsonStringColor = Green
+)
+
+// printJSON prints a json.RawMessage as indented JSON, or compact JSON if the
+// printer is printing on one line. Keys and strings are colored if the value
+// around them is. Each level of the JSON counts as a level of depth, as it
+// would once decoded into an interface{}, so values nested too deep are cut
+// off. See WithMaxDepth(). If v isn't valid JSON, it prints nothing and
+// returns false, so v gets printed as a byte slice.
+func (p *valuePrinter) printJSON(v reflect.Value, showType bool) bool {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, v.Bytes()); err != nil {
+		return false
+	}
+
+	if showType {
+		io.WriteString(p, p.typeName(v.Type()))
+		writeByte(p, '(')
+	}
+	j := &jsonPrinter{valuePrinter: p, b: buf.Bytes()}
+	j.printValue(p.depth, 0)
+	if showType {
+		writeByte(p, ')')
+	}
+	return true
+}
+
+// jsonPrinter prints compact, valid JSON, consuming it as it goes.
+type jsonPrinter struct {
+	*valuePrinter
+	b []byte
+}
+
+// printValue prints the JSON value at the start of j.b. depth is how deep it
+// is in the value being printed, and level how deep it is in the JSON.
+func (j *jsonPrinter) printValue(depth, level int) {
+	if j.depthExceeded(depth) {
+		io.WriteString(j, "!%v(DEPTH EXCEEDED)")
+		j.skip()
+		return
+	}
+
+	open := j.b[0]
+	switch open {
+	case '{', '[':
+	case '"':
+		j.colored(j.token(), jsonStringColor)
+		return
+	default:
+		io.WriteString(j, j.token())
+		return
+	}
+
+	end := byte(']')
+	if open == '{' {
+		end = '}'
+	}
+	writeByte(j, open)
+	j.b = j.b[1:]
+	if j.b[0] == end {
+		writeByte(j, end)
+		j.b = j.b[1:]
+		return
+	}
+
+	for {
+		j.newline(level + 1)
+		if open == '{' {
+			j.colored(j.token(), jsonKeyColor)
+			j.b = j.b[1:] // the colon
+			writeByte(j, ':')
+			if !j.oneLine {
+				writeByte(j, ' ')
+			}
+		}
+		j.printValue(depth+1, level+1)
+
+		c := j.b[0]
+		j.b = j.b[1:]
+		if c == end {
+			break
+		}
+		writeByte(j, ',')
+	}
+	j.newline(level)
+	writeByte(j, end)
+}
+
+// token consumes the string, number, or literal at the start of j.b, and
+// returns it as it's written in the JSON.
+func (j *jsonPrinter) token() string {
+	n := 0
+	if j.b[0] == '"' {
+		for n = 1; j.b[n] != '"'; n++ {
+			if j.b[n] == '\\' {
+				n++
+			}
+		}
+		n++
+	} else {
+		for n < len(j.b) && !strings.ContainsRune(",:]}", rune(j.b[n])) {
+			n++
+		}
+	}
+	tok := string(j.b[:n])
+	j.b = j.b[n:]
+	return tok
+}
+
+// skip consumes the value at the start of j.b without printing it.
+func (j *jsonPrinter) skip() {
+	for nested := 0; ; {
+		switch j.b[0] {
+		case '{', '[':
+			nested++
+			j.b = j.b[1:]
+		case '}', ']':
+			nested--
+			j.b = j.b[1:]
+		default:
+			j.token()
+		}
+		if nested == 0 {
+			return
+		}
+		if j.b[0] == ',' || j.b[0] == ':' {
+			j.b = j.b[1:]
+		}
+	}
+}
+
+// newline starts a new line indented to the given level, unless the printer
+// is printing on one line.
+func (j *jsonPrinter) newline(level int) {
+	if !j.oneLine {
+		io.WriteString(j, "\n"+strings.Repeat("    ", level))
+	}
+}
+
+// colored prints s in color c if the value around it is colored, and resumes
+// that value's color afterwards.
+func (j *jsonPrinter) colored(s string, c Color) {
+	if j.opts.baseColor == "" {
+		io.WriteString(j, s)
+		return
+	}
+	io.WriteString(j, string(c)+s+string(endColor)+string(j.opts.baseColor))
+}
+
+// defaultHexDumpWidth is the number of bytes in each row of a hexdump, unless
+// another width is set with SetHexDumpWidth().
+const defaultHexDumpWidth = 16
+
+// isByteSlice returns true if v is a non-nil []byte, or a named type based on
+// it.
+func isByteSlice(v reflect.Value) bool {
+	return v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 && !v.IsNil()
+}
+
+// printBytesString prints a byte slice as a quoted string, e.g.
+// []uint8("hello").
+func (p *valuePrinter) printBytesString(v reflect.Value, showType bool) {
+	if showType {
+		io.WriteString(p, p.typeName(v.Type()))
+		writeByte(p, '(')
+	}
+	io.WriteString(p, strconv.Quote(string(v.Bytes())))
+	if showType {
+		writeByte(p, ')')
+	}
+}
+
+// printHexDump prints a byte slice as a hexdump, one row per line.
+func (p *valuePrinter) printHexDump(v reflect.Value, showType bool) {
+	if showType {
+		io.WriteString(p, p.typeName(v.Type()))
+	}
+	writeByte(p, '{')
+	if v.Len() == 0 {
+		writeByte(p, '}')
+		return
+	}
+
+	width := p.opts.hexWidth
+	if width <= 0 {
+		width = defaultHexDumpWidth
+	}
+
+	writeByte(p, '\n')
+	pp := p.indent()
+	for _, row := range hexDump(v.Bytes(), width) {
+		io.WriteString(pp, row)
+		writeByte(pp, '\n')
+	}
+	pp.tw.Flush()
+	writeByte(p, '}')
+}
+
+// hexDump returns the rows of a hexdump of b, with width bytes per row, e.g.
+//
+//	00000000  68 65 6c 6c 6f 20 77 6f  72 6c 64 0a              |hello world.|
+//
+// Every 8 bytes are followed by an extra space. The last row is padded, so the
+// text column on the right lines up.
+func hexDump(b []byte, width int) []string {
+	return hexDumpAt(b, 0, width)
+}
+
+// hexDumpAt is like hexDump, but the offsets start at base, for b taken from
+// the middle of a larger dump.
+func hexDumpAt(b []byte, base int64, width int) []string {
+	var rows []string
+	for off := 0; off < len(b); off += width {
+		var hex, text strings.Builder
+		fmt.Fprintf(&hex, "%08x  ", base+int64(off))
+		for i := 0; i < width; i++ {
+			if i > 0 && i%8 == 0 {
+				hex.WriteByte(' ')
+			}
+			if off+i >= len(b) {
+				hex.WriteString("   ")
+				continue
+			}
+
+			c := b[off+i]
+			fmt.Fprintf(&hex, "%02x ", c)
+			if c < 0x20 || c > 0x7e {
+				c = '.'
+			}
+			text.WriteByte(c)
+		}
+		rows = append(rows, hex.String()+" |"+text.String()+"|")
+	}
+	return rows
+}
+
+// timeLayout is how q prints a time.Time. It's the layout of Time.String(),
+// without the monotonic clock reading.
+const timeLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// printTime prints a time.Time in its own location, or in each of the zones
+// given to SetTimeZones(), separated by slashes.
+func (p *valuePrinter) printTime(tm time.Time, showType bool) {
+	if showType {
+		io.WriteString(p, "time.Time(")
+	}
+	if len(p.opts.timeZones) == 0 {
+		io.WriteString(p, tm.Format(timeLayout))
+	}
+	for i, loc := range p.opts.timeZones {
+		if i > 0 {
+			io.WriteString(p, " / ")
+		}
+		io.WriteString(p, tm.In(loc).Format(timeLayout))
+	}
+	if showType {
+		writeByte(p, ')')
+	}
+}
+
+// isContext returns true if v is one of the contexts from the context package,
+// and isn't a nil pointer. Other types that implement context.Context, e.g.
+// request types that embed one, are printed field by field as usual.
+func isContext(v reflect.Value) bool {
+	t := v.Type()
+	if !v.CanInterface() || !t.Implements(contextType) {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Interface:
+		// A context.Context field, which is printed once it's unwrapped, or
+		// nil.
+		return false
+	case reflect.Ptr:
+		if v.IsNil() {
+			return false
+		}
+		t = t.Elem()
+	}
+	return t.PkgPath() == "context"
+}
+
+// printContext prints what matters about a context when debugging timeouts and
+// cancellation: its deadline, how long until the deadline, whether it's done,
+// and why. The chain of parent contexts isn't printed, and neither are the
+// context's values, since there's no way to list them.
+func (p *valuePrinter) printContext(ctx context.Context, showType bool) {
+	if showType {
+		io.WriteString(p, p.typeName(reflect.TypeOf(ctx)))
+	}
+	io.WriteString(p, "{deadline: ")
+	if d, ok := ctx.Deadline(); ok {
+		p.printTime(d, false)
+		now := time.Now
+		if p.opts.now != nil {
+			now = p.opts.now
+		}
+		if left := d.Sub(now()).Round(time.Millisecond); left >= 0 {
+			fmt.Fprintf(p, " (%v left)", left)
+		} else {
+			fmt.Fprintf(p, " (%v ago)", -left)
+		}
+	} else {
+		io.WriteString(p, "none")
+	}
+
+	err := ctx.Err()
+	fmt.Fprintf(p, ", done: %t", err != nil)
+	if err != nil {
+		fmt.Fprintf(p, ", err: %v", err)
+	}
+	writeByte(p, '}')
+}
+
+// isFileInfo returns true if v implements fs.FileInfo, e.g. the result of
+// os.Stat(), and isn't a nil pointer.
+func isFileInfo(v reflect.Value) bool {
+	if !v.CanInterface() || !v.Type().Implements(fileInfoType) {
+		return false
+	}
+	switch v.Kind() {
+	case reflect.Interface:
+		return false
+	case reflect.Ptr:
+		return !v.IsNil()
+	}
+	return true
+}
+
+// printFileInfo prints the useful parts of an fs.FileInfo, e.g.
+//
+//	*os.fileStat{name: "q.go", size: 12.3 KiB, mode: -rw-r--r--, modTime: 2024-03-10 17:04:05 +0000 UTC, dir: false}
+//
+// rather than the internals of the type that implements it.
+func (p *valuePrinter) printFileInfo(fi fs.FileInfo, showType bool) {
+	if showType {
+		io.WriteString(p, p.typeName(reflect.TypeOf(fi)))
+	}
+	fmt.Fprintf(p, "{name: %q, size: %s, mode: %v, modTime: ", fi.Name(), byteSize(fi.Size()), fi.Mode())
+	p.printTime(fi.ModTime(), false)
+	fmt.Fprintf(p, ", dir: %t}", fi.IsDir())
+}
+
+// byteSize returns n bytes as a human-readable size, e.g. 512 B or 1.5 MiB.
+func byteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// defaultFormatterVerb is the verb used to print fmt.Formatters, unless another
+// one is set with SetFormatterVerb().
+const defaultFormatterVerb = "%+v"
+
+// isFormatter returns true if v implements fmt.Formatter, and isn't a nil
+// pointer.
+func isFormatter(v reflect.Value) bool {
+	if !v.CanInterface() || !v.Type().Implements(formatterType) {
+		return false
+	}
+	return v.Kind() != reflect.Ptr || !v.IsNil()
+}
+
+// printFormatter prints a fmt.Formatter using its own Format method.
+func (p *valuePrinter) printFormatter(v reflect.Value, showType bool) {
+	verb := p.opts.formatterVerb
+	if verb == "" {
+		verb = defaultFormatterVerb
+	}
+
+	if showType {
+		io.WriteString(p, p.typeName(v.Type()))
+		writeByte(p, '(')
+	}
+	fmt.Fprintf(p, verb, v.Interface())
+	if showType {
+		writeByte(p, ')')
+	}
+}
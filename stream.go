@@ -0,0 +1,152 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+)
+
+// streamChunkRows is the number of hexdump rows a streamed value is written in
+// at a time, which bounds the memory it takes to write it.
+const streamChunkRows = 2048
+
+// Streamed is a reader whose data is streamed to the log file. See Stream().
+type Streamed struct {
+	r io.Reader
+}
+
+// Stream wraps r so that, when it's passed to Q() and friends, what's read from
+// it is written to the log file as a hexdump, a chunk at a time, instead of
+// being held in memory all at once, e.g.
+//
+//	q.Q(q.Stream(resp.Body))
+//
+// r is read until EOF or an error, so it can't be read again afterwards. The
+// call's line says the data is below, and the hexdump ends with a line giving
+// its size. If the call's output doesn't go to the log file, e.g. with Qbuf()
+// or SetSnapshotDir(), or the file is replaced on each write, with
+// SetAtomicWrite(), r is read into memory and printed like any []byte.
+func Stream(r io.Reader) *Streamed {
+	return &Streamed{r}
+}
+
+// streamValues replaces the values of c that are streamed to the log file
+// with a note saying so, and returns them: readers passed to Stream(), and byte
+// slices longer than SetMaxValueBytes() allows. If the call's output doesn't
+// go to the log file, the readers are read into memory instead, and nothing is
+// streamed. The caller's slice of values isn't modified.
+func (l *logger) streamValues(c *call) []interface{} {
+	stream := !c.buffered && !l.noFile && l.snapshotDir == "" && l.levelFiles[c.level] == "" &&
+		!l.atomicWrites()
+
+	var streams []interface{}
+	values, copied := c.values, false
+	for i, v := range c.values {
+		var note interface{}
+		if s, ok := v.(*Streamed); ok && s != nil {
+			note = "(streamed below)"
+			if !stream {
+				note = readAllStream(s)
+			}
+		} else if rv := reflect.ValueOf(v); stream && l.maxValueBytes > 0 &&
+			isByteSlice(rv) && rv.Len() > l.maxValueBytes {
+			note = fmt.Sprintf("%s(%s, streamed below)", rv.Type(), byteSize(int64(rv.Len())))
+		} else {
+			continue
+		}
+
+		if !copied {
+			values, copied = append([]interface{}(nil), c.values...), true
+		}
+		values[i] = note
+		if stream {
+			streams = append(streams, v)
+		}
+	}
+	c.values = values
+	return streams
+}
+
+// readAllStream reads what a value passed to Stream() would have streamed, so
+// it can be printed like a []byte.
+func readAllStream(s *Streamed) interface{} {
+	b, err := ioutil.ReadAll(s.r)
+	if err != nil {
+		return fmt.Errorf("read %d bytes from stream, then: %v", len(b), err)
+	}
+	return b
+}
+
+// writeStream writes a value returned by streamValues() to the log file as a
+// hexdump, a chunk at a time, followed by a line giving its size. Errors are
+// ignored, as they are when flushing the log buffer.
+func (l *logger) writeStream(v interface{}) {
+	w, done, err := l.logWriter()
+	if err != nil {
+		return
+	}
+	defer done()
+
+	width := l.opts.hexWidth
+	if width <= 0 {
+		width = defaultHexDumpWidth
+	}
+	chunk := width * streamChunkRows
+
+	var off int64
+	write := func(b []byte) error {
+		text := strings.Join(hexDumpAt(b, off, width), "\n") + "\n"
+		off += int64(len(b))
+		if err := l.writeRetry(w, []byte(text)); err != nil {
+			return err
+		}
+		l.stats.bytes += int64(len(text))
+		return nil
+	}
+
+	var readErr error
+	if s, ok := v.(*Streamed); ok {
+		buf := make([]byte, chunk)
+		for {
+			n, err := io.ReadFull(s.r, buf)
+			if n > 0 {
+				if write(buf[:n]) != nil {
+					return
+				}
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			if err != nil {
+				readErr = err
+				break
+			}
+		}
+	} else {
+		b := reflect.ValueOf(v).Bytes()
+		for len(b) > 0 {
+			n := chunk
+			if n > len(b) {
+				n = len(b)
+			}
+			if write(b[:n]) != nil {
+				return
+			}
+			b = b[n:]
+		}
+	}
+
+	end := fmt.Sprintf("(end of streamed data, %s)\n", byteSize(off))
+	if readErr != nil {
+		end = fmt.Sprintf("(streamed data cut short after %s: %v)\n", byteSize(off), readErr)
+	}
+	if l.writeRetry(w, []byte(end)) == nil {
+		l.stats.bytes += int64(len(end))
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestStream verifies that long byte slices and readers passed to Stream() are
+// written to the log file after the call's line, and that short slices are
+// printed as usual.
+func TestStream(t *testing.T) {
+	dir, cleanup := setTempDir(t)
+	defer cleanup()
+
+	l := newLogger()
+	l.maxValueBytes = 8
+	l.opts.hexWidth = 8
+	l.format = FormatPlain
+	l.clock = func() time.Time { return time.Date(2024, 3, 10, 17, 4, 5, 0, time.UTC) }
+	l.log(call{
+		funcName: "main.main",
+		file:     "testdata/sample2.go",
+		line:     9,
+		skip:     1,
+		values:   []interface{}{[]byte("hello world"), Stream(strings.NewReader("hi"))},
+	})
+
+	want := "\n-- [17:04:05 testdata/sample2.go:9 main.main] " + strings.Repeat("-", 34) + "\n" +
+		"0.000s a=[]uint8(11 B, streamed below) b=(streamed below)\n" +
+		"00000000  68 65 6c 6c 6f 20 77 6f  |hello wo|\n" +
+		"00000008  72 6c 64                 |rld|\n" +
+		"(end of streamed data, 11 B)\n" +
+		"00000000  68 69                    |hi|\n" +
+		"(end of streamed data, 2 B)\n"
+	assertFileContents(t, filepath.Join(dir, "q"), want)
+}
+
+// errReader returns some data, then an error.
+type errReader struct {
+	data string
+	err  error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// TestStreamValues verifies that streamValues() only streams when the output
+// goes to the log file, reads readers into memory when it doesn't, and leaves
+// the caller's values alone.
+func TestStreamValues(t *testing.T) {
+	long := []byte("0123456789")
+	values := []interface{}{long, []byte("short"), 1}
+
+	l := newLogger()
+	l.maxValueBytes = 8
+	c := call{values: values}
+	streams := l.streamValues(&c)
+	if want := []interface{}{long}; !reflect.DeepEqual(streams, want) {
+		t.Fatalf("\ngot:  %v\nwant: %v", streams, want)
+	}
+	if got := c.values[0]; got != "[]uint8(10 B, streamed below)" {
+		t.Fatalf("\ngot:  %v\nwant: []uint8(10 B, streamed below)", got)
+	}
+	if !reflect.DeepEqual(values[0], long) {
+		t.Fatalf("the caller's values were modified: %v", values)
+	}
+
+	l.noFile = true
+	c = call{values: []interface{}{long, Stream(strings.NewReader("hi"))}}
+	if streams := l.streamValues(&c); streams != nil {
+		t.Fatalf("got streams %v without a log file, want none", streams)
+	}
+	if want := []interface{}{long, []byte("hi")}; !reflect.DeepEqual(c.values, want) {
+		t.Fatalf("\ngot:  %v\nwant: %v", c.values, want)
+	}
+
+	c = call{values: []interface{}{Stream(&errReader{"hi", io.ErrClosedPipe})}}
+	l.streamValues(&c)
+	want := errors.New("read 2 bytes from stream, then: io: read/write on closed pipe")
+	if !reflect.DeepEqual(c.values, []interface{}{want}) {
+		t.Fatalf("\ngot:  %v\nwant: %v", c.values, want)
+	}
+}
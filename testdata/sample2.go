@@ -0,0 +1,16 @@
+package main
+
+import "github.com/y0ssar1an/q"
+
+func main() {
+	a := 123
+	b := "hello world"
+
+	q.Qn("why we're here", a, b)
+}
+
+func checkpoint() {
+	a := 123
+
+	q.QGroup("checkpoint", a)
+}
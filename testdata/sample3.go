@@ -0,0 +1,8 @@
+package main
+
+import "github.com/y0ssar1an/q"
+
+func main() {
+	queue := []string{"job 1", "job 2", "job 3"}
+	q.QSliceDiff(queue)
+}
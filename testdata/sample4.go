@@ -0,0 +1,23 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/y0ssar1an/q"
+)
+
+type user struct{ Address struct{ City string } }
+
+func (u *user) Name() *strings.Builder { return &strings.Builder{} }
+
+func main() {
+	items := []int{1, 2, 3}
+	u := &user{}
+	q.Q(len(items), items[1], items[1:], u.Address.City, u.Name().Len(), *u)
+	q.Q(len(items /* all of them */), strings.ToUpper(
+		u.Address.City,
+	), 42, "lit")
+	v := &user{}
+	q.Q(u.Address.City, v.Address.City, u.Address.City, 7, 7)
+	q.Q(true, nil, elsewhere, items)
+}
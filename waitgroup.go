@@ -0,0 +1,66 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// WaitGroup is a sync.WaitGroup that keeps count of the goroutines it's
+// waiting for, so it can be logged, e.g. q.Q(&wg) prints
+// &q.WaitGroup{pending=3}. sync.WaitGroup doesn't expose its counter, so to see
+// it you have to use a q.WaitGroup in place of the sync.WaitGroup. It's used
+// the same way, and its zero value is ready to use.
+type WaitGroup struct {
+	sync.WaitGroup
+	pending int64
+}
+
+var waitGroupType = reflect.TypeOf(WaitGroup{})
+
+// Add adds delta, which may be negative, to the WaitGroup counter. See
+// sync.WaitGroup.Add().
+func (wg *WaitGroup) Add(delta int) {
+	atomic.AddInt64(&wg.pending, int64(delta))
+	wg.WaitGroup.Add(delta)
+}
+
+// Done decrements the WaitGroup counter by one. See sync.WaitGroup.Done().
+func (wg *WaitGroup) Done() {
+	wg.Add(-1)
+}
+
+// Go calls f in a new goroutine and adds that goroutine to the WaitGroup.
+func (wg *WaitGroup) Go(f func()) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		f()
+	}()
+}
+
+// Pending returns the number of goroutines the WaitGroup is waiting for.
+func (wg *WaitGroup) Pending() int {
+	return int(atomic.LoadInt64(&wg.pending))
+}
+
+// printWaitGroup prints a WaitGroup as q.WaitGroup{pending=N}.
+func (p *valuePrinter) printWaitGroup(v reflect.Value, showType bool) {
+	var pending int64
+	if v.CanAddr() {
+		pending = atomic.LoadInt64((*int64)(v.FieldByName("pending").Addr().UnsafePointer()))
+	} else {
+		pending = v.FieldByName("pending").Int()
+	}
+
+	if showType {
+		io.WriteString(p, p.typeName(v.Type()))
+	}
+	fmt.Fprintf(p, "{pending=%d}", pending)
+}
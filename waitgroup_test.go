@@ -0,0 +1,44 @@
+// Copyright 2016 Ryan Boehning. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package q
+
+import "testing"
+
+// TestWaitGroup verifies that a WaitGroup counts its pending goroutines, and
+// that formatValue() prints the count.
+func TestWaitGroup(t *testing.T) {
+	var wg WaitGroup
+	release := make(chan struct{})
+	for i := 0; i < 3; i++ {
+		wg.Go(func() { <-release })
+	}
+	wg.Add(2)
+	wg.Done()
+
+	if got := wg.Pending(); got != 4 {
+		t.Fatalf("\ngot:  Pending() = %d\nwant: 4", got)
+	}
+
+	testCases := []struct {
+		id   int
+		arg  interface{}
+		want string
+	}{
+		{1, &wg, "&q.WaitGroup{pending=4}"},
+		{2, struct{ WG *WaitGroup }{&wg}, "struct { WG *q.WaitGroup }{\n    WG: &q.WaitGroup{pending=4},\n}"},
+	}
+	for _, tc := range testCases {
+		if got := formatValue(tc.arg, formatOptions{}); got != tc.want {
+			t.Fatalf("\nTEST %d\ngot:  %s\nwant: %s", tc.id, got, tc.want)
+		}
+	}
+
+	close(release)
+	wg.Done()
+	wg.Wait()
+	if got := wg.Pending(); got != 0 {
+		t.Fatalf("\ngot:  Pending() = %d after Wait()\nwant: 0", got)
+	}
+}